@@ -1,9 +1,29 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+
 	"Second_sprint_final_task/internal/agent"
 )
 
 func main() {
-	agent.Start()
+	// Флаги переопределяют переменные окружения
+	config := agent.ConfigFromEnv()
+	config.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	a, err := agent.New(config)
+	if err != nil {
+		log.Fatalf("Ошибка при запуске агента: %v", err)
+	}
+	if err := a.Start(ctx); err != nil {
+		log.Fatalf("Ошибка при запуске агента: %v", err)
+	}
 }
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os/signal"
+	"syscall"
 
 	"Second_sprint_final_task/internal/application"
 )
 
 func main() {
-	app := application.New()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	app, err := application.New()
+	if err != nil {
+		log.Fatalf("Ошибка при создании сервера: %v", err)
+	}
 	log.Println("Запуск сервера...")
-	if err := app.RunServer(); err != nil {
+	if err := app.RunServer(ctx); err != nil {
 		log.Fatalf("Ошибка при запуске сервера: %v", err)
 	}
 }
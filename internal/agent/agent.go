@@ -2,61 +2,345 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"Second_sprint_final_task/pkg/calculation"
 	"Second_sprint_final_task/pkg/models"
 )
 
+// Version — версия агента, сообщается оркестратору при регистрации
+const Version = "1.0.0"
+
 var (
-	computingPower    int
-	internalTaskURL   = "http://localhost:8080/internal/task"   // URL для получения задачи
-	internalResultURL = "http://localhost:8080/internal/result" // URL для отправки результата
+	errNotRegistered = errors.New("агент не зарегистрирован")
+	errNoTask        = errors.New("нет доступных задач")
 )
 
-func init() {
-	// Чтение переменной среды COMPUTING_POWER
-	computingPower = getEnvAsInt("COMPUTING_POWER", 1)
+// Agent — агент с параметрами из Config: регистрируется у оркестратора, получает задачи
+// и вычисляет их в COMPUTING_POWER воркерах. Агенты не делят состояние, поэтому в одном
+// процессе их может работать несколько
+type Agent struct {
+	id                string
+	computingPower    int           // число воркеров, одновременно получающих и вычисляющих задачи
+	heartbeatInterval time.Duration // уточняется оркестратором при регистрации
+	pollInterval      time.Duration // пауза перед следующим запросом задачи, если задач не было
+	retries           retryPolicy
+	conn              transport     // транспорт, выбранный в Config.Transport
+	results           *outbox       // результаты, которые не удалось отправить сразу
+	running           *runningTasks // задачи, которые сейчас вычисляют воркеры
+	workers           []*worker
 }
 
-func Start() {
-	for {
-		task, err := getTask()
+// New создает агента по параметрам config. Соединение с оркестратором закрывается,
+// когда завершается Start
+func New(config Config) (*Agent, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	a := &Agent{
+		id:                config.AgentID,
+		computingPower:    max(config.ComputingPower, 1),
+		heartbeatInterval: 5 * time.Second,
+		pollInterval:      config.PollInterval,
+		retries: retryPolicy{
+			maxRetries: config.MaxRetries,
+			base:       config.BackoffBase,
+			max:        max(config.BackoffMax, config.BackoffBase),
+		},
+		running: newRunningTasks(),
+	}
+
+	switch config.Transport {
+	case "http":
+		a.conn = newHTTPTransport(config)
+	case "grpc":
+		t, err := newGRPCTransport(config)
 		if err != nil {
-			log.Printf("Ошибка при получении задачи: %v\n", err)
-			time.Sleep(2 * time.Second)
-			continue
+			return nil, fmt.Errorf("ошибка при подключении к gRPC-службе %s: %v", config.GRPCAddr, err)
+		}
+		a.conn = t
+	}
+
+	results, err := newOutbox(config.ResultBufferSize, config.ResultBufferPath, a.conn.sendResult, a.retries)
+	if err != nil {
+		a.conn.close()
+		return nil, err
+	}
+	a.results = results
+	return a, nil
+}
+
+// Start регистрирует агента и запускает воркеров. После отмены ctx воркеры перестают брать
+// новые задачи и дорабатывают текущие, после чего агент снимается с учета у оркестратора.
+// Агент запускается один раз: по завершении Start соединение с оркестратором закрыто
+func (a *Agent) Start(ctx context.Context) error {
+	defer a.conn.close()
+
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	defer stopOutbox()
+	outboxDone := make(chan struct{})
+	go func() {
+		defer close(outboxDone)
+		a.results.run(outboxCtx)
+	}()
+
+	// Оркестратор может запуститься позже агента: регистрируемся, пока не получится
+	for attempt := 0; ; attempt++ {
+		err := a.register()
+		if err == nil {
+			break
+		}
+		log.Printf("Ошибка при регистрации агента: %v\n", err)
+		if !sleep(ctx, a.retries.backoff(attempt)) {
+			return nil
+		}
+	}
+
+	a.workers = make([]*worker, a.computingPower)
+	for i := range a.workers {
+		a.workers[i] = &worker{id: i + 1, agent: a}
+	}
+	heartbeatCtx, stopHeartbeats := context.WithCancel(context.Background())
+	defer stopHeartbeats()
+	heartbeatsDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatsDone)
+		a.sendHeartbeats(heartbeatCtx)
+	}()
+
+	log.Printf("Запущено воркеров: %d\n", len(a.workers))
+	var wg sync.WaitGroup
+	for _, w := range a.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	// Heartbeat отправляются, пока воркеры дорабатывают задачи, чтобы оркестратор не счел агента отключенным
+	stopHeartbeats()
+	<-heartbeatsDone
+	stopOutbox()
+	<-outboxDone
+	if a.results.len() > 0 {
+		if err := a.results.flush(); err != nil {
+			// Если снять агента с учета, его задачи сразу уйдут другим агентам и сохраненные
+			// результаты будут отклонены. Без этого аренда остается за агентом до истечения,
+			// и перезапущенный агент с тем же AgentID успеет отправить результаты
+			log.Printf("Не отправлено результатов: %d: %v. Агент не снимается с учета\n", a.results.len(), err)
+			return nil
 		}
+	}
+	if err := a.retries.retry(context.Background(), a.conn.deregister); err != nil {
+		log.Printf("Ошибка при снятии агента с учета: %v\n", err)
+		return nil
+	}
+	log.Printf("Агент %s остановлен\n", a.id)
+	return nil
+}
+
+// sleep ждет d или отмены ctx. Возвращает false, если ctx отменен
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// transport — способ связи агента с оркестратором: HTTP (httpTransport) или gRPC (grpcTransport)
+type transport interface {
+	// register регистрирует агента и возвращает интервал heartbeat, если оркестратор его сообщил
+	register(agent models.Agent) (time.Duration, error)
+	// heartbeat возвращает ID задач отмененных выражений, которые агент может бросить,
+	// или errNotRegistered, если оркестратор не знает агента
+	heartbeat(workers []models.WorkerStats) ([]string, error)
+	deregister() error
+	// getTask ждет задачу не дольше Config.TaskWait и возвращает errNoTask, если она не появилась
+	getTask(ctx context.Context) (models.Task, error)
+	sendResult(result models.Result) error
+	// close закрывает соединение с оркестратором
+	close() error
+}
+
+// register сообщает оркестратору об агенте и получает от него интервал heartbeat
+func (a *Agent) register() error {
+	interval, err := a.conn.register(models.Agent{
+		ID:             a.id,
+		ComputingPower: a.computingPower,
+		Version:        Version,
+		Capabilities:   []string{models.TaskOperation, models.TaskFunction, models.PrecisionDecimal},
+	})
+	if err != nil {
+		return err
+	}
+	if interval > 0 {
+		a.heartbeatInterval = interval
+	}
+
+	log.Printf("Агент %s зарегистрирован, интервал heartbeat: %v\n", a.id, a.heartbeatInterval)
+	return nil
+}
 
-		result, err := performCalculation(task)
+// sendHeartbeats периодически сообщает оркестратору, что агент на связи.
+// Если оркестратор забыл агента (например, после перезапуска), агент регистрируется заново
+func (a *Agent) sendHeartbeats(ctx context.Context) {
+	for sleep(ctx, a.heartbeatInterval) {
+		err := a.heartbeat()
+		if errors.Is(err, errNotRegistered) {
+			err = a.register()
+		}
 		if err != nil {
-			log.Printf("Ошибка при выполнении вычисления: %v\n", err)
-			continue
+			log.Printf("Ошибка при отправке heartbeat: %v\n", err)
 		}
+	}
+}
+
+// heartbeat сообщает оркестратору, что агент на связи, и передает счетчики воркеров.
+// Задачи, отмененные оркестратором, помечаются, чтобы воркеры не отправляли их результаты
+func (a *Agent) heartbeat() error {
+	stats := make([]models.WorkerStats, len(a.workers))
+	for i, w := range a.workers {
+		stats[i] = w.stats()
+	}
+	cancelled, err := a.conn.heartbeat(stats)
+	if n := a.running.cancel(cancelled); n > 0 {
+		log.Printf("Оркестратор отменил выполняемые задачи: %d\n", n)
+	}
+	return err
+}
+
+// httpTransport связывается с оркестратором через HTTP API /internal/*
+type httpTransport struct {
+	client         *http.Client // общий для всех воркеров; таймауты задаются контекстом запроса
+	agentID        string
+	taskURL        string // URL для получения задачи
+	resultURL      string // URL для отправки результата
+	agentsURL      string // URL для регистрации и heartbeat
+	requestTimeout time.Duration
+	taskWait       time.Duration
+}
+
+func newHTTPTransport(config Config) httpTransport {
+	base := strings.TrimSuffix(config.OrchestratorURL, "/")
+	return httpTransport{
+		client:         &http.Client{},
+		agentID:        config.AgentID,
+		taskURL:        base + "/internal/task",
+		resultURL:      base + "/internal/result",
+		agentsURL:      base + "/internal/agents",
+		requestTimeout: config.RequestTimeout,
+		taskWait:       config.TaskWait,
+	}
+}
+
+func (t httpTransport) register(agent models.Agent) (time.Duration, error) {
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при кодировании сведений об агенте: %v", err)
+	}
+
+	resp, err := t.postJSON(t.agentsURL, data)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при регистрации: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return 0, fmt.Errorf("оркестратор вернул статус: %d", resp.StatusCode)
+	}
+
+	var response struct {
+		HeartbeatIntervalMs int64 `json:"heartbeat_interval_ms"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, nil
+	}
+	return time.Duration(response.HeartbeatIntervalMs) * time.Millisecond, nil
+}
 
-		if err := sendResult(task.ID, result); err != nil {
-			log.Printf("Ошибка при отправке результата: %v\n", err)
+func (t httpTransport) heartbeat(workers []models.WorkerStats) ([]string, error) {
+	data, err := json.Marshal(map[string]interface{}{"workers": workers})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при кодировании heartbeat: %v", err)
+	}
+
+	resp, err := t.postJSON(t.agentsURL+"/"+t.agentID+"/heartbeat", data)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при отправке heartbeat: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Старые версии оркестратора отвечают без тела
+		var response struct {
+			CancelledTasks []string `json:"cancelled_tasks"`
 		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		return response.CancelledTasks, nil
+	case http.StatusNotFound:
+		return nil, errNotRegistered
+	}
+	return nil, fmt.Errorf("оркестратор вернул статус: %d", resp.StatusCode)
+}
 
-		log.Printf("Задача с ID %s успешно обработана, результат: %f\n", task.ID, result)
-		time.Sleep(2 * time.Second)
+// deregister сообщает оркестратору, что агент завершает работу
+func (t httpTransport) deregister() error {
+	ctx, cancel := requestContext(context.Background(), t.requestTimeout, 0)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.agentsURL+"/"+t.agentID, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка при создании запроса: %v", err)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ошибка при снятии с учета: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("оркестратор вернул статус: %d", resp.StatusCode)
 	}
+	return nil
 }
 
-func getTask() (models.Task, error) {
-	resp, err := http.Get(internalTaskURL) // Используем переменную internalTaskURL
+// getTask запрашивает задачу у оркестратора, ожидая ее не дольше taskWait.
+// Если задача так и не появилась, возвращает errNoTask. Отмена ctx прерывает запрос
+func (t httpTransport) getTask(ctx context.Context) (models.Task, error) {
+	ctx, cancel := requestContext(ctx, t.requestTimeout, t.taskWait)
+	defer cancel()
+	query := url.Values{"agent_id": {t.agentID}, "wait": {t.taskWait.String()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.taskURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return models.Task{}, fmt.Errorf("ошибка при создании запроса: %v", err)
+	}
+	resp, err := t.client.Do(req)
 	if err != nil {
 		return models.Task{}, fmt.Errorf("ошибка при запросе задачи: %v", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNoContent {
+		return models.Task{}, errNoTask
+	}
 	if resp.StatusCode != http.StatusOK {
 		return models.Task{}, fmt.Errorf("оркестратор вернул статус: %d", resp.StatusCode)
 	}
@@ -66,55 +350,126 @@ func getTask() (models.Task, error) {
 		return models.Task{}, fmt.Errorf("ошибка при декодировании задачи: %v", err)
 	}
 
-	log.Printf("Получена задача: %+v\n", task)
 	return task, nil
 }
 
-func performCalculation(task models.Task) (float64, error) {
-	// Формируем строку выражения
-	var expressionBuilder strings.Builder
-	for i, num := range task.Numbers {
-		expressionBuilder.WriteString(fmt.Sprintf("%f", num))
-		if i < len(task.Operators) {
-			expressionBuilder.WriteString(" " + task.Operators[i] + " ")
-		}
+func (t httpTransport) sendResult(result models.Result) error {
+	data, err := encodeResult(result)
+	if err != nil {
+		return err
 	}
 
-	expression := expressionBuilder.String()
-	result, err := calculation.Calc(expression)
+	resp, err := t.postJSON(t.resultURL, data)
 	if err != nil {
-		return 0, fmt.Errorf("ошибка при вычислении выражения: %v", err)
+		return fmt.Errorf("ошибка при отправке результата: %v", err)
 	}
+	defer resp.Body.Close()
 
-	// Корректируем время выполнения в зависимости от COMPUTING_POWER
-	time.Sleep(time.Duration(100/computingPower) * time.Millisecond)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: статус %d", errRejected, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("оркестратор вернул статус: %d", resp.StatusCode)
+	}
 
-	return result, nil
+	return nil
 }
 
-func sendResult(taskID string, result float64) error {
-	resultData := models.Result{
-		ID:     taskID,
-		Result: result,
-	}
+// close закрывает неиспользуемые соединения клиента
+func (t httpTransport) close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
 
-	data, err := json.Marshal(resultData)
+// postJSON отправляет оркестратору POST-запрос с телом в JSON. Запрос ограничен requestTimeout,
+// поэтому тело ответа нужно прочитать до истечения таймаута
+func (t httpTransport) postJSON(url string, data []byte) (*http.Response, error) {
+	ctx, cancel := requestContext(context.Background(), t.requestTimeout, 0)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(data))
 	if err != nil {
-		return fmt.Errorf("ошибка при кодировании результата: %v", err)
+		cancel()
+		return nil, err
 	}
-
-	resp, err := http.Post(internalResultURL, "application/json", bytes.NewBuffer(data)) // Используем переменную internalResultURL
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("ошибка при отправке результата: %v", err)
+		cancel()
+		return nil, err
 	}
-	defer resp.Body.Close()
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("оркестратор вернул статус: %d", resp.StatusCode)
+// cancelOnClose отменяет контекст запроса при закрытии тела ответа
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func performCalculation(task models.Task) (models.Result, error) {
+	if task.Precision == models.PrecisionDecimal {
+		return performDecimalCalculation(task)
 	}
 
-	log.Printf("Результат для задачи с ID %s успешно отправлен\n", taskID)
-	return nil
+	var result float64
+	var err error
+	if task.Type == models.TaskFunction {
+		result, err = calculation.Call(task.Function, task.Args)
+		if err != nil {
+			return models.Result{}, fmt.Errorf("ошибка при вычислении %s%v: %w", task.Function, task.Args, err)
+		}
+	} else {
+		result, err = calculation.Apply(task.Operation, task.Arg1, task.Arg2)
+		if err != nil {
+			return models.Result{}, fmt.Errorf("ошибка при вычислении %v %s %v: %w", task.Arg1, task.Operation, task.Arg2, err)
+		}
+	}
+
+	return models.Result{ID: task.ID, Result: result}, nil
+}
+
+// performDecimalCalculation вычисляет задачу точно. Результат передается дробью ("1/3"),
+// чтобы следующие задачи получили его без потерь
+func performDecimalCalculation(task models.Task) (models.Result, error) {
+	args := make([]*big.Rat, len(task.DecimalArgs))
+	for i, arg := range task.DecimalArgs {
+		val, err := calculation.ParseDecimal(arg)
+		if err != nil {
+			return models.Result{}, fmt.Errorf("неверный аргумент %q: %w", arg, err)
+		}
+		args[i] = val
+	}
+
+	var result *big.Rat
+	var err error
+	if task.Type == models.TaskFunction {
+		result, err = calculation.CallDecimal(task.Function, args)
+		if err != nil {
+			return models.Result{}, fmt.Errorf("ошибка при вычислении %s%v: %w", task.Function, task.DecimalArgs, err)
+		}
+	} else {
+		if len(args) != 2 {
+			return models.Result{}, fmt.Errorf("ошибка при вычислении %s: %w", task.Operation, calculation.ErrInvalidValuesCount)
+		}
+		result, err = calculation.ApplyDecimal(task.Operation, args[0], args[1])
+		if err != nil {
+			return models.Result{}, fmt.Errorf("ошибка при вычислении %s %s %s: %w", task.DecimalArgs[0], task.Operation, task.DecimalArgs[1], err)
+		}
+	}
+
+	approx, _ := result.Float64()
+	return models.Result{ID: task.ID, Result: approx, Decimal: result.RatString()}, nil
+}
+
+// failedResult описывает ошибку вычисления задачи для сервера
+func failedResult(task models.Task, err error) models.Result {
+	return models.Result{ID: task.ID, ErrorCode: calculation.ErrorCode(err), Error: err.Error()}
 }
 
 func getEnvAsInt(key string, defaultValue int) int {
@@ -1,33 +1,65 @@
 package agent
 
 import (
-	"SSFT/pkg/models"
+	"Second_sprint_final_task/pkg/calculation"
+	"Second_sprint_final_task/pkg/models"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
+// testAgentID — ID агента в тестах
+const testAgentID = "agent1"
+
+// Вспомогательная функция, создающая агента с параметрами по умолчанию, который обращается
+// к оркестратору по адресу url
+func newTestAgent(t *testing.T, url string) *Agent {
+	t.Helper()
+	config := DefaultConfig()
+	config.AgentID = testAgentID
+	config.OrchestratorURL = url
+	a, err := New(config)
+	if err != nil {
+		t.Fatalf("Ошибка при создании агента: %v", err)
+	}
+	t.Cleanup(func() { a.conn.close() })
+	return a
+}
+
+// Вспомогательная функция, создающая HTTP-транспорт к оркестратору по адресу url
+func newTestTransport(url string) httpTransport {
+	config := DefaultConfig()
+	config.AgentID = testAgentID
+	config.OrchestratorURL = url
+	return newHTTPTransport(config)
+}
+
 func TestGetTask(t *testing.T) {
 	// Создаем тестовый сервер
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Агент ждет задачу на стороне оркестратора
+		if r.URL.Path != "/internal/task" || r.URL.Query().Get("wait") != "30s" || r.URL.Query().Get("agent_id") != testAgentID {
+			t.Errorf("Неверные параметры запроса задачи: %s", r.URL.RawQuery)
+		}
 		task := models.Task{
-			ID:        "123",
-			Numbers:   []float64{1, 2, 3},
-			Operators: []string{"+", "-"},
+			ID:           "123",
+			ExpressionID: "expr-1",
+			Arg1:         1,
+			Arg2:         2,
+			Operation:    "+",
 		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(task)
 	}))
 	defer server.Close()
 
-	// Подменяем URL на тестовый сервер
-	oldURL := internalTaskURL
-	internalTaskURL = server.URL
-	defer func() { internalTaskURL = oldURL }()
-
-	task, err := getTask()
+	task, err := newTestTransport(server.URL).getTask(context.Background())
 	if err != nil {
 		t.Fatalf("Ошибка при получении задачи: %v", err)
 	}
@@ -36,19 +68,32 @@ func TestGetTask(t *testing.T) {
 		t.Errorf("Ожидаемый ID задачи: 123, получено: %s", task.ID)
 	}
 
-	if len(task.Numbers) != 3 || task.Numbers[0] != 1 || task.Numbers[1] != 2 || task.Numbers[2] != 3 {
-		t.Errorf("Ожидаемые числа: [1, 2, 3], получено: %v", task.Numbers)
+	if task.Arg1 != 1 || task.Arg2 != 2 {
+		t.Errorf("Ожидаемые аргументы: 1, 2, получено: %v, %v", task.Arg1, task.Arg2)
 	}
 
-	if len(task.Operators) != 2 || task.Operators[0] != "+" || task.Operators[1] != "-" {
-		t.Errorf("Ожидаемые операторы: [+, -], получено: %v", task.Operators)
+	if task.Operation != "+" {
+		t.Errorf("Ожидаемая операция: +, получено: %s", task.Operation)
+	}
+}
+
+func TestGetTaskNoContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if _, err := newTestTransport(server.URL).getTask(context.Background()); !errors.Is(err, errNoTask) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", errNoTask, err)
 	}
 }
 
 func TestPerformCalculation(t *testing.T) {
 	task := models.Task{
-		Numbers:   []float64{1, 2, 3},
-		Operators: []string{"+", "-"},
+		ID:        "task-1",
+		Arg1:      6,
+		Arg2:      4,
+		Operation: "-",
 	}
 
 	result, err := performCalculation(task)
@@ -56,10 +101,85 @@ func TestPerformCalculation(t *testing.T) {
 		t.Fatalf("Ошибка при выполнении вычисления: %v", err)
 	}
 
-	// Ожидаемый результат зависит от логики функции calculation.Calc
-	expectedResult := 0.0 // Замените на ожидаемый результат
-	if result != expectedResult {
-		t.Errorf("Ожидаемый результат: %f, получено: %f", expectedResult, result)
+	expectedResult := 2.0
+	if result.Result != expectedResult || result.ID != "task-1" {
+		t.Errorf("Ожидаемый результат: %f для задачи task-1, получено: %f для задачи %s", expectedResult, result.Result, result.ID)
+	}
+
+	// Отрицательные аргументы и результат
+	result, err = performCalculation(models.Task{Arg1: -2.5, Arg2: -4, Operation: "*"})
+	if err != nil || result.Result != 10 {
+		t.Errorf("Ожидаемый результат: 10, получено: %f (%v)", result.Result, err)
+	}
+	result, err = performCalculation(models.Task{Arg1: 0, Arg2: 3, Operation: "-"})
+	if err != nil || result.Result != -3 {
+		t.Errorf("Ожидаемый результат: -3, получено: %f (%v)", result.Result, err)
+	}
+
+	// Новые операторы
+	for _, tt := range []struct {
+		task     models.Task
+		expected float64
+	}{
+		{models.Task{Arg1: 2, Arg2: 3, Operation: "^"}, 8},
+		{models.Task{Arg1: 7, Arg2: 3, Operation: "%"}, 1},
+		{models.Task{Arg1: 7, Arg2: 2, Operation: "//"}, 3},
+	} {
+		result, err := performCalculation(tt.task)
+		if err != nil || result.Result != tt.expected {
+			t.Errorf("Ожидаемый результат %s: %f, получено: %f (%v)", tt.task.Operation, tt.expected, result.Result, err)
+		}
+	}
+
+	// Задачи-вызовы функций
+	result, err = performCalculation(models.Task{Type: models.TaskFunction, Function: "max", Args: []float64{1, 5, 3}})
+	if err != nil || result.Result != 5 {
+		t.Errorf("Ожидаемый результат max: 5, получено: %f (%v)", result.Result, err)
+	}
+	if _, err := performCalculation(models.Task{Type: models.TaskFunction, Function: "sqrt", Args: []float64{-1}}); !errors.Is(err, calculation.ErrNegativeSqrt) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", calculation.ErrNegativeSqrt, err)
+	}
+
+	// Деление на ноль должно вернуть ошибку, о которой агент сообщит серверу
+	for _, op := range []string{"/", "%", "//"} {
+		task := models.Task{ID: "task-0", Arg1: 1, Arg2: 0, Operation: op}
+		_, err := performCalculation(task)
+		if err == nil {
+			t.Fatalf("Ожидалась ошибка при делении на ноль для операции %s", op)
+		}
+		if result := failedResult(task, err); result.ID != "task-0" || result.ErrorCode != calculation.CodeDivisionByZero || result.Error == "" {
+			t.Errorf("Ожидался результат с кодом %s, получено: %+v", calculation.CodeDivisionByZero, result)
+		}
+	}
+}
+
+func TestPerformDecimalCalculation(t *testing.T) {
+	tests := []struct {
+		task     models.Task
+		expected string
+	}{
+		{models.Task{Type: models.TaskOperation, Operation: "+", Precision: models.PrecisionDecimal, DecimalArgs: []string{"0.1", "0.2"}}, "3/10"},
+		{models.Task{Type: models.TaskOperation, Operation: "/", Precision: models.PrecisionDecimal, DecimalArgs: []string{"1", "3"}}, "1/3"},
+		{models.Task{Type: models.TaskOperation, Operation: "*", Precision: models.PrecisionDecimal, DecimalArgs: []string{"1/3", "3"}}, "1"},
+		{models.Task{Type: models.TaskOperation, Operation: "-", Precision: models.PrecisionDecimal, DecimalArgs: []string{"0", "2.5"}}, "-5/2"},
+		{models.Task{Type: models.TaskFunction, Function: "max", Precision: models.PrecisionDecimal, DecimalArgs: []string{"0.1", "0.3", "0.2"}}, "3/10"},
+	}
+
+	for _, tt := range tests {
+		result, err := performCalculation(tt.task)
+		if err != nil {
+			t.Fatalf("Ошибка при выполнении вычисления %+v: %v", tt.task, err)
+		}
+		if result.Decimal != tt.expected {
+			t.Errorf("Ожидаемый точный результат: %s, получено: %s", tt.expected, result.Decimal)
+		}
+	}
+
+	if _, err := performCalculation(models.Task{Operation: "/", Precision: models.PrecisionDecimal, DecimalArgs: []string{"1", "0"}}); !errors.Is(err, calculation.ErrInvalidZero) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", calculation.ErrInvalidZero, err)
+	}
+	if _, err := performCalculation(models.Task{Operation: "+", Precision: models.PrecisionDecimal, DecimalArgs: []string{"abc", "1"}}); err == nil {
+		t.Error("Ожидалась ошибка для неверного аргумента")
 	}
 }
 
@@ -84,17 +204,128 @@ func TestSendResult(t *testing.T) {
 	}))
 	defer server.Close()
 
-	// Подменяем URL на тестовый сервер
-	oldURL := internalResultURL
-	internalResultURL = server.URL
-	defer func() { internalResultURL = oldURL }()
-
-	err := sendResult("123", 42.0)
+	err := newTestTransport(server.URL).sendResult(models.Result{ID: "123", Result: 42.0})
 	if err != nil {
 		t.Fatalf("Ошибка при отправке результата: %v", err)
 	}
 }
 
+func TestSendNegativeResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resultData models.Result
+		if err := json.NewDecoder(r.Body).Decode(&resultData); err != nil {
+			t.Errorf("Ошибка при декодировании результата: %v", err)
+		}
+		if resultData.Result != -0.125 {
+			t.Errorf("Ожидаемый результат: -0.125, получено: %f", resultData.Result)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if err := newTestTransport(server.URL).sendResult(models.Result{ID: "123", Result: -0.125}); err != nil {
+		t.Fatalf("Ошибка при отправке результата: %v", err)
+	}
+}
+
+func TestRegisterAndHeartbeat(t *testing.T) {
+	var registered models.Agent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal/agents":
+			json.NewDecoder(r.Body).Decode(&registered)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]int64{"heartbeat_interval_ms": 1500})
+		case "/internal/agents/" + testAgentID + "/heartbeat":
+			// Оркестратор знает агента только после регистрации
+			if registered.ID == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			t.Errorf("Неожиданный запрос: %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	a := newTestAgent(t, server.URL)
+	if err := a.heartbeat(); !errors.Is(err, errNotRegistered) {
+		t.Errorf("Ожидалась ошибка: %v, получено: %v", errNotRegistered, err)
+	}
+
+	if err := a.register(); err != nil {
+		t.Fatalf("Ошибка при регистрации: %v", err)
+	}
+	if registered.ID != testAgentID || registered.ComputingPower != 1 || registered.Version != Version {
+		t.Errorf("Неверные сведения об агенте: %+v", registered)
+	}
+	if a.heartbeatInterval != 1500*time.Millisecond {
+		t.Errorf("Ожидаемый интервал heartbeat: 1.5s, получено: %v", a.heartbeatInterval)
+	}
+
+	if err := a.heartbeat(); err != nil {
+		t.Errorf("Ошибка при отправке heartbeat: %v", err)
+	}
+}
+
+func TestStartShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/internal/agents":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]int64{"heartbeat_interval_ms": 10})
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/internal/task":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.OrchestratorURL = server.URL + "/"
+	config.ComputingPower = 2
+	config.PollInterval = 10 * time.Millisecond
+	a, err := New(config)
+	if err != nil {
+		t.Fatalf("Ошибка при создании агента: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.Start(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Ошибка при запуске агента: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Агент не остановился после отмены контекста")
+	}
+	if len(a.workers) != 2 {
+		t.Errorf("Ожидалось 2 воркера, получено: %d", len(a.workers))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if last := requests[len(requests)-1]; last != "DELETE /internal/agents/"+config.AgentID {
+		t.Errorf("Ожидалось, что агент снимется с учета последним запросом, получено: %s", last)
+	}
+}
+
 func TestGetEnvAsInt(t *testing.T) {
 	// Устанавливаем переменную окружения
 	os.Setenv("TEST_ENV", "42")
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config — параметры агента. Заполняется из переменных окружения (ConfigFromEnv),
+// а флаги командной строки (BindFlags) их переопределяют
+type Config struct {
+	AgentID         string        // ID агента; по умолчанию случайный
+	OrchestratorURL string        // адрес HTTP API оркестратора
+	GRPCAddr        string        // адрес gRPC-службы оркестратора
+	Transport       string        // http или grpc
+	ComputingPower  int           // число воркеров
+	RequestTimeout  time.Duration // таймаут запроса к оркестратору; запрос задачи может длиться еще TaskWait
+	TaskWait        time.Duration // сколько оркестратор держит запрос задачи при пустой очереди
+	PollInterval    time.Duration // пауза перед следующим запросом задачи, если задач не было
+	MaxRetries      int           // сколько раз повторять неудавшийся запрос
+	BackoffBase     time.Duration // пауза перед первым повтором, дальше удваивается
+	BackoffMax      time.Duration // наибольшая пауза между повторами
+	// Результаты, которые не удалось отправить, хранятся в буфере на ResultBufferSize результатов;
+	// если задан ResultBufferPath, буфер сохраняется в этот файл и переживает перезапуск
+	ResultBufferSize int
+	ResultBufferPath string
+}
+
+// defaultResultBufferSize — размер буфера неотправленных результатов по умолчанию
+const defaultResultBufferSize = 100
+
+// DefaultConfig возвращает параметры агента по умолчанию
+func DefaultConfig() Config {
+	return Config{
+		AgentID:          uuid.New().String(),
+		OrchestratorURL:  "http://localhost:8080",
+		GRPCAddr:         "localhost:9090",
+		Transport:        "http",
+		ComputingPower:   1,
+		RequestTimeout:   10 * time.Second,
+		TaskWait:         30 * time.Second,
+		MaxRetries:       5,
+		BackoffBase:      200 * time.Millisecond,
+		BackoffMax:       10 * time.Second,
+		ResultBufferSize: defaultResultBufferSize,
+	}
+}
+
+// ConfigFromEnv читает параметры агента из переменных окружения; незаданные берутся из DefaultConfig
+func ConfigFromEnv() Config {
+	config := DefaultConfig()
+	if value := os.Getenv("AGENT_ID"); value != "" {
+		config.AgentID = value
+	}
+	if value := os.Getenv("ORCHESTRATOR_URL"); value != "" {
+		config.OrchestratorURL = value
+	}
+	if value := os.Getenv("ORCHESTRATOR_GRPC_ADDR"); value != "" {
+		config.GRPCAddr = value
+	}
+	if value := os.Getenv("AGENT_TRANSPORT"); value != "" {
+		config.Transport = value
+	}
+	config.ComputingPower = getEnvAsInt("COMPUTING_POWER", config.ComputingPower)
+	config.RequestTimeout = getEnvAsMs("REQUEST_TIMEOUT_MS", config.RequestTimeout)
+	config.TaskWait = getEnvAsMs("TASK_WAIT_MS", config.TaskWait)
+	config.PollInterval = getEnvAsMs("POLL_INTERVAL_MS", config.PollInterval)
+	config.MaxRetries = getEnvAsInt("MAX_RETRIES", config.MaxRetries)
+	config.BackoffBase = getEnvAsMs("BACKOFF_BASE_MS", config.BackoffBase)
+	config.BackoffMax = getEnvAsMs("BACKOFF_MAX_MS", config.BackoffMax)
+	config.ResultBufferSize = getEnvAsInt("RESULT_BUFFER_SIZE", config.ResultBufferSize)
+	if value := os.Getenv("RESULT_BUFFER_PATH"); value != "" {
+		config.ResultBufferPath = value
+	}
+	return config
+}
+
+// BindFlags добавляет в fs флаги, которые при разборе переопределяют поля config
+func (c *Config) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.AgentID, "agent-id", c.AgentID, "ID агента")
+	fs.StringVar(&c.OrchestratorURL, "orchestrator-url", c.OrchestratorURL, "адрес HTTP API оркестратора")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", c.GRPCAddr, "адрес gRPC-службы оркестратора")
+	fs.StringVar(&c.Transport, "transport", c.Transport, "транспорт: http или grpc")
+	fs.IntVar(&c.ComputingPower, "computing-power", c.ComputingPower, "число воркеров")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "таймаут запроса к оркестратору")
+	fs.DurationVar(&c.TaskWait, "task-wait", c.TaskWait, "сколько оркестратор ждет задачу при пустой очереди")
+	fs.DurationVar(&c.PollInterval, "poll-interval", c.PollInterval, "пауза между запросами задачи, если задач нет")
+	fs.IntVar(&c.MaxRetries, "max-retries", c.MaxRetries, "сколько раз повторять неудавшийся запрос")
+	fs.DurationVar(&c.BackoffBase, "backoff-base", c.BackoffBase, "пауза перед первым повтором")
+	fs.DurationVar(&c.BackoffMax, "backoff-max", c.BackoffMax, "наибольшая пауза между повторами")
+	fs.IntVar(&c.ResultBufferSize, "result-buffer-size", c.ResultBufferSize, "сколько неотправленных результатов хранить")
+	fs.StringVar(&c.ResultBufferPath, "result-buffer-path", c.ResultBufferPath, "файл для неотправленных результатов")
+}
+
+// validate проверяет, что с параметрами можно запустить агента
+func (c Config) validate() error {
+	if c.AgentID == "" {
+		return fmt.Errorf("не задан ID агента")
+	}
+	if c.Transport != "http" && c.Transport != "grpc" {
+		return fmt.Errorf("неизвестный транспорт агента: %s", c.Transport)
+	}
+	if u, err := url.Parse(c.OrchestratorURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("неверный адрес оркестратора: %q", c.OrchestratorURL)
+	}
+	if c.RequestTimeout < 0 || c.TaskWait < 0 || c.PollInterval < 0 || c.BackoffBase < 0 || c.BackoffMax < 0 || c.MaxRetries < 0 {
+		return fmt.Errorf("таймауты, паузы и число повторов не могут быть отрицательными")
+	}
+	return nil
+}
+
+// getEnvAsMs читает из переменной окружения длительность в миллисекундах
+func getEnvAsMs(key string, defaultValue time.Duration) time.Duration {
+	value := getEnvAsInt(key, -1)
+	if value < 0 {
+		return defaultValue
+	}
+	return time.Duration(value) * time.Millisecond
+}
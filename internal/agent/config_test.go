@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnvAndFlags(t *testing.T) {
+	t.Setenv("AGENT_ID", "agent-7")
+	t.Setenv("ORCHESTRATOR_URL", "http://orchestrator:8080")
+	t.Setenv("COMPUTING_POWER", "4")
+	t.Setenv("REQUEST_TIMEOUT_MS", "1500")
+	t.Setenv("MAX_RETRIES", "7")
+	t.Setenv("BACKOFF_BASE_MS", "not a number")
+
+	config := ConfigFromEnv()
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	config.BindFlags(fs)
+	if err := fs.Parse([]string{"-computing-power", "8", "-poll-interval", "250ms", "-transport", "grpc"}); err != nil {
+		t.Fatalf("Ошибка при разборе флагов: %v", err)
+	}
+
+	expected := DefaultConfig()
+	expected.AgentID = "agent-7"
+	expected.OrchestratorURL = "http://orchestrator:8080"
+	expected.ComputingPower = 8 // флаг важнее переменной окружения
+	expected.RequestTimeout = 1500 * time.Millisecond
+	expected.MaxRetries = 7
+	expected.PollInterval = 250 * time.Millisecond
+	expected.Transport = "grpc"
+	if config != expected {
+		t.Errorf("Ожидаемые параметры: %+v, получено: %+v", expected, config)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"по умолчанию", func(c *Config) {}, false},
+		{"неизвестный транспорт", func(c *Config) { c.Transport = "udp" }, true},
+		{"адрес без схемы", func(c *Config) { c.OrchestratorURL = "orchestrator:8080" }, true},
+		{"отрицательный таймаут", func(c *Config) { c.RequestTimeout = -time.Second }, true},
+		{"пустой ID агента", func(c *Config) { c.AgentID = "" }, true},
+		{"gRPC", func(c *Config) { c.Transport = "grpc" }, false},
+	}
+	for _, tt := range tests {
+		config := DefaultConfig()
+		tt.modify(&config)
+		a, err := New(config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ожидалась ошибка: %v, получено: %v", tt.name, tt.wantErr, err)
+		}
+		if a != nil {
+			a.conn.close()
+		}
+	}
+
+	// Два агента в одном процессе не делят параметры
+	first, second := DefaultConfig(), DefaultConfig()
+	first.OrchestratorURL, first.ComputingPower = "http://first:8080/", 0
+	second.OrchestratorURL, second.ComputingPower = "http://second:8080", 3
+	a, _ := New(first)
+	b, _ := New(second)
+	defer a.conn.close()
+	defer b.conn.close()
+	if tr := a.conn.(httpTransport); tr.taskURL != "http://first:8080/internal/task" || tr.agentsURL != "http://first:8080/internal/agents" {
+		t.Errorf("Неверные адреса оркестратора: %s, %s", tr.taskURL, tr.agentsURL)
+	}
+	if tr := b.conn.(httpTransport); tr.resultURL != "http://second:8080/internal/result" {
+		t.Errorf("Неверный адрес отправки результатов: %s", tr.resultURL)
+	}
+	if a.id == b.id || a.computingPower != 1 || b.computingPower != 3 {
+		t.Errorf("Ожидались разные агенты с 1 и 3 воркерами, получено: %s (%d), %s (%d)", a.id, a.computingPower, b.id, b.computingPower)
+	}
+}
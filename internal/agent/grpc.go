@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"Second_sprint_final_task/internal/grpcapi"
+	"Second_sprint_final_task/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// grpcTransport связывается с оркестратором через gRPC-службу (Config.Transport = grpc).
+// Соединение устанавливается при первом вызове и общее для всех воркеров
+type grpcTransport struct {
+	cc             *grpc.ClientConn
+	client         grpcapi.TaskServiceClient
+	agentID        string
+	requestTimeout time.Duration
+	taskWait       time.Duration
+}
+
+func newGRPCTransport(config Config, opts ...grpc.DialOption) (grpcTransport, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	cc, err := grpc.NewClient(config.GRPCAddr, opts...)
+	if err != nil {
+		return grpcTransport{}, err
+	}
+	return grpcTransport{
+		cc:             cc,
+		client:         grpcapi.NewTaskServiceClient(cc),
+		agentID:        config.AgentID,
+		requestTimeout: config.RequestTimeout,
+		taskWait:       config.TaskWait,
+	}, nil
+}
+
+func (t grpcTransport) register(agent models.Agent) (time.Duration, error) {
+	ctx, cancel := requestContext(context.Background(), t.requestTimeout, 0)
+	defer cancel()
+	resp, err := t.client.Register(ctx, grpcapi.AgentToProto(agent))
+	if err != nil {
+		return 0, fmt.Errorf("ошибка при регистрации: %v", err)
+	}
+	return time.Duration(resp.HeartbeatIntervalMs) * time.Millisecond, nil
+}
+
+func (t grpcTransport) heartbeat(workers []models.WorkerStats) ([]string, error) {
+	ctx, cancel := requestContext(context.Background(), t.requestTimeout, 0)
+	defer cancel()
+	resp, err := t.client.Heartbeat(ctx, &grpcapi.HeartbeatRequest{AgentId: t.agentID, Workers: grpcapi.WorkersToProto(workers)})
+	if status.Code(err) == codes.NotFound {
+		return nil, errNotRegistered
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при отправке heartbeat: %v", err)
+	}
+	return resp.CancelledTasks, nil
+}
+
+func (t grpcTransport) deregister() error {
+	ctx, cancel := requestContext(context.Background(), t.requestTimeout, 0)
+	defer cancel()
+	_, err := t.client.Deregister(ctx, &grpcapi.DeregisterRequest{AgentId: t.agentID})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("ошибка при снятии с учета: %v", err)
+	}
+	return nil
+}
+
+func (t grpcTransport) getTask(ctx context.Context) (models.Task, error) {
+	ctx, cancel := requestContext(ctx, t.requestTimeout, t.taskWait)
+	defer cancel()
+	task, err := t.client.GetTask(ctx, &grpcapi.GetTaskRequest{AgentId: t.agentID, WaitMs: t.taskWait.Milliseconds()})
+	if status.Code(err) == codes.NotFound {
+		return models.Task{}, errNoTask
+	}
+	if err != nil {
+		return models.Task{}, fmt.Errorf("ошибка при запросе задачи: %v", err)
+	}
+	return grpcapi.TaskFromProto(task), nil
+}
+
+func (t grpcTransport) sendResult(result models.Result) error {
+	// Сервер отдает результаты выражений в JSON: результат, который нельзя закодировать в JSON,
+	// не отправляем и по gRPC, как и по HTTP
+	if _, err := encodeResult(result); err != nil {
+		return err
+	}
+	ctx, cancel := requestContext(context.Background(), t.requestTimeout, 0)
+	defer cancel()
+	_, err := t.client.SubmitResult(ctx, grpcapi.ResultToProto(result))
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition:
+		return fmt.Errorf("%w: %v", errRejected, status.Convert(err).Message())
+	default:
+		return fmt.Errorf("ошибка при отправке результата: %v", err)
+	}
+	return nil
+}
+
+func (t grpcTransport) close() error {
+	return t.cc.Close()
+}
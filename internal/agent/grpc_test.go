@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"Second_sprint_final_task/internal/grpcapi"
+	"Second_sprint_final_task/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeTaskService — служба оркестратора с одной задачей, запоминающая вызовы агента
+type fakeTaskService struct {
+	grpcapi.UnimplementedTaskServiceServer
+	mu         sync.Mutex
+	registered bool
+	task       *models.Task
+	results    []models.Result
+	workers    []models.WorkerStats
+	cancelled  []string // задачи, о которых сообщает следующий heartbeat
+	waitMs     int64
+}
+
+func (s *fakeTaskService) Register(_ context.Context, agent *grpcapi.Agent) (*grpcapi.RegisterResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registered = agent.GetId() == testAgentID
+	return &grpcapi.RegisterResponse{Id: agent.GetId(), HeartbeatIntervalMs: 250}, nil
+}
+
+func (s *fakeTaskService) Heartbeat(_ context.Context, req *grpcapi.HeartbeatRequest) (*grpcapi.HeartbeatResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.registered || req.GetAgentId() != testAgentID {
+		return nil, status.Error(codes.NotFound, "агент не зарегистрирован")
+	}
+	s.workers = grpcapi.WorkersFromProto(req.GetWorkers())
+	cancelled := s.cancelled
+	s.cancelled = nil
+	return &grpcapi.HeartbeatResponse{CancelledTasks: cancelled}, nil
+}
+
+func (s *fakeTaskService) Deregister(context.Context, *grpcapi.DeregisterRequest) (*grpcapi.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.registered {
+		return nil, status.Error(codes.NotFound, "агент не зарегистрирован")
+	}
+	s.registered = false
+	return &grpcapi.Empty{}, nil
+}
+
+func (s *fakeTaskService) GetTask(_ context.Context, req *grpcapi.GetTaskRequest) (*grpcapi.Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitMs = req.GetWaitMs()
+	if s.task == nil {
+		return nil, status.Error(codes.NotFound, "нет доступных задач")
+	}
+	task := s.task
+	s.task = nil
+	return grpcapi.TaskToProto(*task), nil
+}
+
+func (s *fakeTaskService) SubmitResult(_ context.Context, result *grpcapi.Result) (*grpcapi.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, grpcapi.ResultFromProto(result))
+	return &grpcapi.Empty{}, nil
+}
+
+// Вспомогательная функция, подключающая grpcTransport к службе через bufconn
+func newTestGRPCTransport(t *testing.T, srv grpcapi.TaskServiceServer) grpcTransport {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	grpcapi.RegisterTaskServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	config := DefaultConfig()
+	config.AgentID = testAgentID
+	config.GRPCAddr = "passthrough:///bufnet"
+	tr, err := newGRPCTransport(config,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatalf("Ошибка при создании транспорта: %v", err)
+	}
+	t.Cleanup(func() { tr.close() })
+	return tr
+}
+
+func TestGRPCTransport(t *testing.T) {
+	srv := &fakeTaskService{}
+	tr := newTestGRPCTransport(t, srv)
+
+	if _, err := tr.heartbeat(nil); !errors.Is(err, errNotRegistered) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", errNotRegistered, err)
+	}
+	interval, err := tr.register(models.Agent{ID: testAgentID, ComputingPower: 1})
+	if err != nil || interval != 250*time.Millisecond {
+		t.Fatalf("Ожидался интервал heartbeat 250ms, получено: %v, ошибка: %v", interval, err)
+	}
+	stats := []models.WorkerStats{{ID: 1, Processed: 3}}
+	srv.cancelled = []string{"task0"}
+	if cancelled, err := tr.heartbeat(stats); err != nil || len(cancelled) != 1 || cancelled[0] != "task0" {
+		t.Errorf("Ожидалась отмененная задача task0, получено: %v, ошибка: %v", cancelled, err)
+	}
+
+	if _, err := tr.getTask(context.Background()); !errors.Is(err, errNoTask) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", errNoTask, err)
+	}
+	srv.task = &models.Task{ID: "task1", Arg1: 1, Arg2: 2, Operation: "+", LeaseToken: "token"}
+	task, err := tr.getTask(context.Background())
+	if err != nil || task.ID != "task1" || task.LeaseToken != "token" {
+		t.Fatalf("Ожидалась задача task1, получено: %+v, ошибка: %v", task, err)
+	}
+	if err := tr.sendResult(models.Result{ID: task.ID, Result: 3, LeaseToken: task.LeaseToken}); err != nil {
+		t.Errorf("Ошибка при отправке результата: %v", err)
+	}
+
+	// Повторное снятие с учета не считается ошибкой, как и в HTTP-транспорте
+	for i := 0; i < 2; i++ {
+		if err := tr.deregister(); err != nil {
+			t.Errorf("Ошибка при снятии с учета: %v", err)
+		}
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.waitMs != tr.taskWait.Milliseconds() {
+		t.Errorf("Ожидаемое время ожидания задачи: %d мс, получено: %d", tr.taskWait.Milliseconds(), srv.waitMs)
+	}
+	if len(srv.workers) != 1 || srv.workers[0] != stats[0] {
+		t.Errorf("Ожидаемые счетчики воркеров: %+v, получено: %+v", stats, srv.workers)
+	}
+	if len(srv.results) != 1 || srv.results[0].Result != 3 || srv.results[0].LeaseToken != "token" {
+		t.Errorf("Ожидался результат 3, получено: %+v", srv.results)
+	}
+}
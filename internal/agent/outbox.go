@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+// errRejected — оркестратор отказался принять результат; повторять отправку бессмысленно
+var errRejected = errors.New("оркестратор отклонил результат")
+
+// errInvalidResult — результат нельзя закодировать для отправки (например, бесконечность
+// или NaN в JSON). Повторная отправка того же результата тоже не удастся
+var errInvalidResult = errors.New("результат нельзя закодировать")
+
+// outboxRetryInterval — как часто буфер пробует отправить результаты, если новых не поступало
+const outboxRetryInterval = 5 * time.Second
+
+// outbox — ограниченный буфер результатов, которые не удалось отправить оркестратору.
+// Буфер отправляет их в фоне функцией send с паузами retries.backoff. Если задан путь к файлу,
+// содержимое буфера сохраняется в него и переживает перезапуск агента
+type outbox struct {
+	mu      sync.Mutex
+	results []models.Result
+	limit   int
+	path    string
+	notify  chan struct{} // сигнал, что в буфере появились результаты
+	send    func(models.Result) error
+	retries retryPolicy
+}
+
+// newOutbox создает буфер на limit результатов и загружает результаты, сохраненные в path
+// при прошлом запуске. Пустой path — буфер только в памяти
+func newOutbox(limit int, path string, send func(models.Result) error, retries retryPolicy) (*outbox, error) {
+	o := &outbox{limit: max(limit, 1), path: path, notify: make(chan struct{}, 1), send: send, retries: retries}
+	if path == "" {
+		return o, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при чтении буфера результатов: %w", err)
+	}
+	if err := json.Unmarshal(data, &o.results); err != nil {
+		return nil, fmt.Errorf("неверный формат буфера результатов %s: %w", path, err)
+	}
+	if len(o.results) > o.limit {
+		o.results = o.results[len(o.results)-o.limit:]
+	}
+	if len(o.results) > 0 {
+		log.Printf("Загружено неотправленных результатов: %d\n", len(o.results))
+		o.signal()
+	}
+	return o, nil
+}
+
+// encodeResult кодирует результат в JSON. Ошибка кодирования оборачивает errInvalidResult
+func encodeResult(result models.Result) ([]byte, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidResult, err)
+	}
+	return data, nil
+}
+
+// add кладет результат в буфер. Если буфер полон, самый старый результат отбрасывается:
+// его задачу оркестратор, скорее всего, уже выдал другому агенту. Результат, который
+// нельзя закодировать, в буфер не попадает: иначе он не дал бы сохранить весь буфер
+func (o *outbox) add(result models.Result) {
+	if _, err := encodeResult(result); err != nil {
+		log.Printf("Результат задачи %s отброшен: %v\n", result.ID, err)
+		return
+	}
+	o.mu.Lock()
+	if len(o.results) >= o.limit {
+		log.Printf("Буфер результатов полон, результат задачи %s отброшен\n", o.results[0].ID)
+		o.results = o.results[1:]
+	}
+	o.results = append(o.results, result)
+	o.save()
+	o.mu.Unlock()
+	o.signal()
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// len возвращает число неотправленных результатов
+func (o *outbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.results)
+}
+
+// flush отправляет результаты по порядку до первой ошибки связи. Отклоненные оркестратором
+// и незакодируемые результаты удаляются из буфера, остальные остаются до следующей попытки
+func (o *outbox) flush() error {
+	o.mu.Lock()
+	pending := append([]models.Result(nil), o.results...)
+	o.mu.Unlock()
+
+	sent := 0
+	var err error
+	for _, result := range pending {
+		err = o.send(result)
+		if errors.Is(err, errRejected) || errors.Is(err, errInvalidResult) {
+			log.Printf("Результат задачи %s отброшен: %v\n", result.ID, err)
+			err = nil
+		}
+		if err != nil {
+			break
+		}
+		sent++
+	}
+	if sent == 0 {
+		return err
+	}
+
+	// Пока шла отправка, в буфер могли добавиться результаты, а старые — вытесниться
+	delivered := make(map[string]bool, sent)
+	for _, result := range pending[:sent] {
+		delivered[result.ID] = true
+	}
+	o.mu.Lock()
+	kept := o.results[:0]
+	for _, result := range o.results {
+		if !delivered[result.ID] {
+			kept = append(kept, result)
+		}
+	}
+	o.results = kept
+	o.save()
+	o.mu.Unlock()
+	return err
+}
+
+// run отправляет результаты из буфера до отмены ctx. После неудачной отправки паузы растут по backoff
+func (o *outbox) run(ctx context.Context) {
+	failures := 0
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.notify:
+		case <-ticker.C:
+		}
+		if o.len() == 0 {
+			continue
+		}
+		if err := o.flush(); err != nil {
+			log.Printf("Ошибка при отправке результатов из буфера: %v\n", err)
+			if !sleep(ctx, o.retries.backoff(failures)) {
+				return
+			}
+			failures++
+			o.signal()
+			continue
+		}
+		failures = 0
+	}
+}
+
+// save записывает буфер в файл через временный файл, чтобы при сбое не остался обрезанный JSON.
+// Вызывается под mu
+func (o *outbox) save() {
+	if o.path == "" {
+		return
+	}
+	data, err := json.Marshal(o.results)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(o.path), "."+filepath.Base(o.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, o.path)
+		}
+	}
+	if err != nil {
+		log.Printf("Ошибка при сохранении буфера результатов: %v\n", err)
+	}
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+// Вспомогательная функция, запускающая тестовый сервер для отправки результатов и возвращающая
+// его адрес. Сервер отвечает статусами из statuses по очереди, а затем 200, и запоминает принятые результаты
+func newResultServer(t *testing.T, statuses ...int) (string, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var accepted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result models.Result
+		json.NewDecoder(r.Body).Decode(&result)
+		mu.Lock()
+		defer mu.Unlock()
+		code := http.StatusOK
+		if len(statuses) > 0 {
+			code, statuses = statuses[0], statuses[1:]
+		}
+		if code == http.StatusOK {
+			accepted = append(accepted, result.ID)
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), accepted...)
+	}
+}
+
+func TestOutboxLimit(t *testing.T) {
+	box, _ := newOutbox(2, "", nil, retryPolicy{})
+	for _, id := range []string{"task1", "task2", "task3"} {
+		box.add(models.Result{ID: id})
+	}
+	if box.len() != 2 || box.results[0].ID != "task2" {
+		t.Errorf("Ожидалось, что самый старый результат будет вытеснен, получено: %+v", box.results)
+	}
+}
+
+func TestOutboxFlush(t *testing.T) {
+	url, accepted := newResultServer(t, http.StatusOK, http.StatusBadRequest, http.StatusServiceUnavailable)
+	box, _ := newOutbox(10, "", newTestTransport(url).sendResult, retryPolicy{})
+	for _, id := range []string{"task1", "task2", "task3", "task4"} {
+		box.add(models.Result{ID: id})
+	}
+
+	// task1 принят, task2 отклонен и отброшен, на task3 оркестратор недоступен
+	if err := box.flush(); err == nil {
+		t.Fatal("Ожидалась ошибка отправки")
+	}
+	if box.len() != 2 || box.results[0].ID != "task3" {
+		t.Fatalf("Ожидались неотправленные task3 и task4, получено: %+v", box.results)
+	}
+
+	if err := box.flush(); err != nil {
+		t.Fatalf("Неожиданная ошибка отправки: %v", err)
+	}
+	if got := accepted(); len(got) != 3 || got[0] != "task1" || got[2] != "task4" {
+		t.Errorf("Ожидались принятые task1, task3 и task4, получено: %v", got)
+	}
+	if box.len() != 0 {
+		t.Errorf("Ожидался пустой буфер, получено: %+v", box.results)
+	}
+}
+
+func TestOutboxInvalidResult(t *testing.T) {
+	url, accepted := newResultServer(t)
+	path := filepath.Join(t.TempDir(), "results.json")
+	box, _ := newOutbox(10, path, newTestTransport(url).sendResult, retryPolicy{})
+
+	// Бесконечность нельзя закодировать в JSON: такой результат в буфер не попадает
+	box.add(models.Result{ID: "inf", Result: math.Inf(1)})
+	box.add(models.Result{ID: "task1", Result: 1})
+	if box.len() != 1 || box.results[0].ID != "task1" {
+		t.Fatalf("Ожидался только task1 в буфере, получено: %+v", box.results)
+	}
+
+	// Если такой результат все же оказался в буфере, он отбрасывается, а не блокирует остальные
+	box.results = append([]models.Result{{ID: "nan", Result: math.NaN()}}, box.results...)
+	if err := box.flush(); err != nil {
+		t.Fatalf("Неожиданная ошибка отправки: %v", err)
+	}
+	if got := accepted(); len(got) != 1 || got[0] != "task1" {
+		t.Errorf("Ожидался принятый task1, получено: %v", got)
+	}
+	if again, _ := newOutbox(10, path, nil, retryPolicy{}); box.len() != 0 || again.len() != 0 {
+		t.Errorf("Ожидался пустой буфер и файл, получено: %+v, %+v", box.results, again.results)
+	}
+}
+
+func TestOutboxPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	url, _ := newResultServer(t)
+	send := newTestTransport(url).sendResult
+	box, err := newOutbox(10, path, send, retryPolicy{})
+	if err != nil {
+		t.Fatalf("Ошибка при создании буфера: %v", err)
+	}
+	box.add(models.Result{ID: "task1", Result: 3})
+	box.add(models.Result{ID: "task2", Decimal: "1/3"})
+
+	// После перезапуска агента результаты загружаются из файла
+	restored, err := newOutbox(10, path, send, retryPolicy{})
+	if err != nil {
+		t.Fatalf("Ошибка при загрузке буфера: %v", err)
+	}
+	if restored.len() != 2 || restored.results[0].Result != 3 || restored.results[1].Decimal != "1/3" {
+		t.Fatalf("Ожидались 2 сохраненных результата, получено: %+v", restored.results)
+	}
+
+	if err := restored.flush(); err != nil {
+		t.Fatalf("Неожиданная ошибка отправки: %v", err)
+	}
+	if again, _ := newOutbox(10, path, send, retryPolicy{}); again.len() != 0 {
+		t.Errorf("Ожидалось, что отправленные результаты удалятся из файла, получено: %+v", again.results)
+	}
+}
+
+func TestOutboxRun(t *testing.T) {
+	url, accepted := newResultServer(t, http.StatusBadGateway, http.StatusBadGateway)
+	box, _ := newOutbox(10, "", newTestTransport(url).sendResult, retryPolicy{base: time.Millisecond, max: 5 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		box.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Буфер повторяет отправку с паузами, пока оркестратор не примет результат
+	box.add(models.Result{ID: "task1"})
+	deadline := time.Now().Add(time.Second)
+	for box.len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Результат не отправлен из буфера")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := accepted(); len(got) != 1 || got[0] != "task1" {
+		t.Errorf("Ожидался принятый task1, получено: %v", got)
+	}
+}
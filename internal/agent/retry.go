@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// retryPolicy — сколько раз и с какими паузами повторять неудавшиеся запросы к оркестратору
+type retryPolicy struct {
+	maxRetries int           // сколько раз повторять неудавшийся запрос
+	base       time.Duration // пауза перед первым повтором, дальше удваивается
+	max        time.Duration // наибольшая пауза между повторами
+}
+
+// backoff возвращает паузу перед повтором с номером attempt (с нуля): base * 2^attempt,
+// но не больше max. Пауза выбирается случайно между половиной и полным значением,
+// чтобы агенты, потерявшие связь одновременно, не повторяли запросы разом
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.max
+	if attempt < 32 {
+		if exp := p.base << attempt; exp > 0 && exp < p.max {
+			d = exp
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// retry выполняет op и после ошибки повторяет его не больше maxRetries раз с паузами backoff.
+// Ответы errNoTask, errNotRegistered, errRejected и errInvalidResult — не сбои, они не повторяются. Отмена ctx прекращает
+// повторы. Возвращает последнюю ошибку
+func (p retryPolicy) retry(ctx context.Context, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || errors.Is(err, errNoTask) || errors.Is(err, errNotRegistered) || errors.Is(err, errRejected) || errors.Is(err, errInvalidResult) || attempt >= p.maxRetries {
+			return err
+		}
+		if !sleep(ctx, p.backoff(attempt)) {
+			return err
+		}
+	}
+}
+
+// requestContext ограничивает запрос к оркестратору временем timeout + extra; при timeout <= 0
+// запрос не ограничен
+func requestContext(ctx context.Context, timeout, extra time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout+extra)
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := retryPolicy{base: 100 * time.Millisecond, max: time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := policy.backoff(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("Попытка %d: ожидалась пауза от %v до %v, получено: %v", tt.attempt, tt.max/2, tt.max, d)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := retryPolicy{maxRetries: 3, base: time.Millisecond, max: time.Millisecond}
+
+	failing := errors.New("сбой сети")
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		calls    int
+		wantErr  error
+	}{
+		{"успех с первого раза", 0, failing, 1, nil},
+		{"успех после повторов", 2, failing, 3, nil},
+		{"повторы исчерпаны", 10, failing, 4, failing},
+		{"агент не зарегистрирован", 10, errNotRegistered, 1, errNotRegistered},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := policy.retry(context.Background(), func() error {
+			calls++
+			if calls <= tt.failures {
+				return tt.err
+			}
+			return nil
+		})
+		if calls != tt.calls || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: ожидалось вызовов: %d и ошибка %v, получено: %d и %v", tt.name, tt.calls, tt.wantErr, calls, err)
+		}
+	}
+
+	// Отмененный контекст прекращает повторы
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	policy.retry(ctx, func() error { calls++; return failing })
+	if calls != 1 {
+		t.Errorf("Ожидался 1 вызов при отмененном контексте, получено: %d", calls)
+	}
+}
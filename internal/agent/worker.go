@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+// worker — горутина агента, которая по очереди получает задачи, вычисляет их и отправляет результаты.
+// Агент запускает COMPUTING_POWER воркеров, все они используют общее соединение с оркестратором
+type worker struct {
+	id        int
+	agent     *Agent
+	processed atomic.Int64 // задач, вычисленных успешно
+	failed    atomic.Int64 // задач, вычисление которых завершилось ошибкой
+	busy      atomic.Bool
+}
+
+// runningTasks помнит выполняемые задачи и отмечает те, что отменил оркестратор:
+// результат отмененной задачи не нужен и не отправляется
+type runningTasks struct {
+	mu    sync.Mutex
+	tasks map[string]bool // true — задача отменена
+}
+
+func newRunningTasks() *runningTasks {
+	return &runningTasks{tasks: make(map[string]bool)}
+}
+
+func (r *runningTasks) start(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks[id] = false
+}
+
+// cancel отмечает отмененными те из задач ids, которые сейчас выполняются, и возвращает их число
+func (r *runningTasks) cancel(ids []string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		if _, ok := r.tasks[id]; ok {
+			r.tasks[id] = true
+			n++
+		}
+	}
+	return n
+}
+
+// finish забывает задачу и сообщает, была ли она отменена
+func (r *runningTasks) finish(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancelled := r.tasks[id]
+	delete(r.tasks, id)
+	return cancelled
+}
+
+// run получает и вычисляет задачи до отмены ctx. Начатая задача всегда доводится до конца,
+// а ее результат отправляется серверу. Задачи запрашиваются с ожиданием на стороне
+// оркестратора, поэтому после пустого ответа пауза — Config.PollInterval (по умолчанию ее нет),
+// а после ошибок паузы растут по backoff
+func (w *worker) run(ctx context.Context) {
+	failures := 0
+	for ctx.Err() == nil {
+		task, err := w.agent.conn.getTask(ctx)
+		if errors.Is(err, errNoTask) {
+			failures = 0
+			sleep(ctx, w.agent.pollInterval)
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logf("Ошибка при получении задачи: %v", err)
+			}
+			sleep(ctx, w.agent.retries.backoff(failures))
+			failures++
+			continue
+		}
+
+		failures = 0
+		w.process(task)
+	}
+	w.logf("Воркер остановлен")
+}
+
+// process вычисляет задачу и отправляет результат серверу. Если за время вычисления
+// оркестратор отменил задачу, результат отбрасывается
+func (w *worker) process(task models.Task) {
+	w.agent.running.start(task.ID)
+	w.busy.Store(true)
+	defer w.busy.Store(false)
+	w.logf("Получена задача: %+v", task)
+
+	result, err := performCalculation(task)
+	if w.agent.running.finish(task.ID) {
+		w.logf("Задача %s отменена оркестратором, результат отброшен", task.ID)
+		return
+	}
+	if err == nil {
+		// Результат, который нельзя закодировать, до оркестратора не дойдет: вместо него
+		// отправляем ошибку
+		_, err = encodeResult(result)
+	}
+	if err != nil {
+		// Сообщаем серверу об ошибке, чтобы выражение не осталось в обработке
+		w.logf("Ошибка при выполнении вычисления: %v", err)
+		result = failedResult(task, err)
+		w.failed.Add(1)
+	} else {
+		w.processed.Add(1)
+	}
+	// По ключу аренды сервер проверяет, что задача все еще за этим агентом, а по выражению —
+	// что результат относится к той задаче, которую он планировал
+	result.ExpressionID, result.LeaseToken = task.ExpressionID, task.LeaseToken
+
+	// Результат отправляется и после отмены контекста агента, чтобы не вычислять задачу заново
+	err = w.agent.retries.retry(context.Background(), func() error { return w.agent.conn.sendResult(result) })
+	if errors.Is(err, errRejected) || errors.Is(err, errInvalidResult) {
+		w.logf("Результат задачи %s отброшен: %v", task.ID, err)
+		return
+	}
+	if err != nil {
+		// Результат не потерян: буфер отправит его, когда связь восстановится
+		w.logf("Ошибка при отправке результата, результат сохранен в буфер: %v", err)
+		w.agent.results.add(result)
+		return
+	}
+	if result.ErrorCode == "" {
+		w.logf("Задача с ID %s успешно обработана, результат: %v", task.ID, result.Result)
+	}
+}
+
+func (w *worker) stats() models.WorkerStats {
+	return models.WorkerStats{
+		ID:        w.id,
+		Processed: w.processed.Load(),
+		Failed:    w.failed.Load(),
+		Busy:      w.busy.Load(),
+	}
+}
+
+// logf пишет в лог сообщение с ID воркера
+func (w *worker) logf(format string, args ...interface{}) {
+	log.Printf("[воркер %d] "+format, append([]interface{}{w.id}, args...)...)
+}
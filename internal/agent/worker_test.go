@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"Second_sprint_final_task/pkg/calculation"
+	"Second_sprint_final_task/pkg/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerProcess(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]models.Result)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result models.Result
+		json.NewDecoder(r.Body).Decode(&result)
+		mu.Lock()
+		received[result.ID] = result
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Воркеры работают одновременно и считают задачи независимо друг от друга
+	a := newTestAgent(t, server.URL)
+	first, second := &worker{id: 1, agent: a}, &worker{id: 2, agent: a}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		first.process(models.Task{ID: "ok-1", ExpressionID: "expr", Arg1: 2, Arg2: 3, Operation: "+", LeaseToken: "token"})
+		first.process(models.Task{ID: "ok-2", Arg1: 2, Arg2: 3, Operation: "*"})
+	}()
+	go func() {
+		defer wg.Done()
+		second.process(models.Task{ID: "zero", Arg1: 1, Arg2: 0, Operation: "/"})
+	}()
+	wg.Wait()
+
+	if stats := first.stats(); !reflect.DeepEqual(stats, models.WorkerStats{ID: 1, Processed: 2}) {
+		t.Errorf("Неверные счетчики первого воркера: %+v", stats)
+	}
+	if stats := second.stats(); !reflect.DeepEqual(stats, models.WorkerStats{ID: 2, Failed: 1}) {
+		t.Errorf("Неверные счетчики второго воркера: %+v", stats)
+	}
+
+	if received["ok-1"].Result != 5 || received["ok-2"].Result != 6 {
+		t.Errorf("Ожидались результаты 5 и 6, получено: %+v", received)
+	}
+	if received["ok-1"].ExpressionID != "expr" || received["ok-1"].LeaseToken != "token" {
+		t.Errorf("Ожидались выражение и ключ аренды задачи, получено: %+v", received["ok-1"])
+	}
+	if received["zero"].ErrorCode != calculation.CodeDivisionByZero {
+		t.Errorf("Ожидалась ошибка %s, получено: %+v", calculation.CodeDivisionByZero, received["zero"])
+	}
+}
+
+func TestHeartbeatWorkerStats(t *testing.T) {
+	var body struct {
+		Workers []models.WorkerStats `json:"workers"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	a := newTestAgent(t, server.URL)
+	a.workers = []*worker{{id: 1, agent: a}, {id: 2, agent: a}}
+	a.workers[0].processed.Add(3)
+	a.workers[1].busy.Store(true)
+
+	if err := a.heartbeat(); err != nil {
+		t.Fatalf("Ошибка при отправке heartbeat: %v", err)
+	}
+	expected := []models.WorkerStats{{ID: 1, Processed: 3}, {ID: 2, Busy: true}}
+	if !reflect.DeepEqual(body.Workers, expected) {
+		t.Errorf("Ожидаемые счетчики воркеров: %+v, получено: %+v", expected, body.Workers)
+	}
+}
+
+func TestWorkerProcessBuffersResult(t *testing.T) {
+	url, _ := newResultServer(t, http.StatusServiceUnavailable, http.StatusBadRequest)
+	a := newTestAgent(t, url)
+	a.retries.maxRetries = 0
+
+	// Оркестратор недоступен: результат остается в буфере
+	w := &worker{id: 1, agent: a}
+	w.process(models.Task{ID: "task1", Arg1: 2, Arg2: 3, Operation: "+"})
+	if a.results.len() != 1 || a.results.results[0].Result != 5 {
+		t.Fatalf("Ожидался результат 5 в буфере, получено: %+v", a.results.results)
+	}
+
+	// Отклоненный оркестратором результат не повторяется и в буфер не попадает
+	w.process(models.Task{ID: "task2", Arg1: 2, Arg2: 3, Operation: "*"})
+	if a.results.len() != 1 {
+		t.Errorf("Ожидался 1 результат в буфере, получено: %+v", a.results.results)
+	}
+}
+
+func TestWorkerDropsCancelledResult(t *testing.T) {
+	var mu sync.Mutex
+	var received []models.Result
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal/agents/"+testAgentID+"/heartbeat" {
+			json.NewEncoder(w).Encode(map[string][]string{"cancelled_tasks": {"task1", "finished"}})
+			return
+		}
+		var result models.Result
+		json.NewDecoder(r.Body).Decode(&result)
+		mu.Lock()
+		received = append(received, result)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	// Оркестратор отменяет задачу, пока воркер ее вычисляет
+	a := newTestAgent(t, server.URL)
+	w := &worker{id: 1, agent: a}
+	a.workers = []*worker{w}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.process(models.Task{ID: "task1", Arg1: 2, Arg2: 3, Operation: "+"})
+	}()
+	for !w.busy.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.heartbeat(); err != nil {
+		t.Fatalf("Ошибка при отправке heartbeat: %v", err)
+	}
+	<-done
+
+	// Задача, которой у воркера нет, не мешает следующим задачам с тем же ID
+	w.process(models.Task{ID: "finished", Arg1: 2, Arg2: 3, Operation: "*"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0].ID != "finished" || received[0].Result != 6 {
+		t.Errorf("Ожидался только результат задачи finished, получено: %+v", received)
+	}
+}
@@ -1,200 +1,982 @@
 package application
 
 import (
+	"Second_sprint_final_task/internal/orchestrator"
+	"Second_sprint_final_task/internal/storage"
+	"Second_sprint_final_task/pkg/calculation"
 	"Second_sprint_final_task/pkg/models"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 )
 
-var (
-	expressionsMutex = &sync.Mutex{}
-	expressions      = make(map[string]*models.Expression)
-	tasks            = make(chan models.Task, 100)
+const (
+	defaultQueueSize     = 100                   // размер очереди задач по умолчанию
+	defaultShutdownGrace = 10 * time.Second      // сколько ждать выданные задачи при остановке по умолчанию
+	leaseCheckInterval   = time.Second           // как часто проверяются истекшие аренды задач
+	drainCheckInterval   = 50 * time.Millisecond // как часто при остановке проверяются выданные задачи
+	maxTaskWait          = time.Minute           // наибольшее время ожидания задачи в GET /internal/task
+	defaultPageSize      = 100                   // выражений на странице GET /api/v1/expressions по умолчанию
+	maxPageSize          = 1000                  // наибольший размер страницы GET /api/v1/expressions
 )
 
+// Коды ошибок выражения в дополнение к кодам calculation
+const (
+	CodeAttemptsExhausted = "ATTEMPTS_EXHAUSTED" // задача не выполнена ни за одну из попыток
+	CodeInvalidRequest    = "INVALID_REQUEST"    // неверные параметры выражения: точность, переменные, callback_url
+)
+
+// ErrUnknownTask — результат прислан для задачи неизвестного выражения
+var ErrUnknownTask = errors.New("задача не найдена")
+
+// taskNode — задача в графе зависимостей выражения
+type taskNode struct {
+	task    models.Task
+	pending int    // число аргументов, которые еще ждут результата другой задачи
+	parent  string // ID задачи, которой нужен результат этой; пусто для корня
+	slot    int    // номер аргумента в родительской задаче, начиная с 1
+}
+
+// operand — аргумент задачи: значение или ссылка на задачу, результат которой его заменит
+type operand struct {
+	value   float64
+	decimal *big.Rat
+	ref     string
+}
+
+// setArg подставляет результат зависимой задачи в аргумент с номером slot
+func (tn *taskNode) setArg(slot int, result models.Result) {
+	if tn.task.Precision == models.PrecisionDecimal {
+		tn.task.DecimalArgs[slot-1] = result.Decimal
+	}
+	switch {
+	case tn.task.Type == models.TaskFunction:
+		tn.task.Args[slot-1] = result.Result
+	case slot == 1:
+		tn.task.Arg1 = result.Result
+	default:
+		tn.task.Arg2 = result.Result
+	}
+}
+
+// finalResult готовит итоговый результат выражения к сохранению. decimal — точный результат
+// в виде, понятном calculation.ParseDecimal; в режиме PrecisionDecimal он форматируется
+// по calculation.Decimal, в остальных отбрасывается
+func finalResult(precision string, value float64, decimal string) (float64, string) {
+	if precision != models.PrecisionDecimal {
+		return value, ""
+	}
+	exact, err := calculation.ParseDecimal(decimal)
+	if err != nil {
+		log.Printf("Неверный точный результат: %q", decimal)
+		return value, ""
+	}
+	value, _ = exact.Float64()
+	return value, calculation.FormatDecimal(exact, calculation.Decimal)
+}
+
+// floatVariables переводит значения переменных запроса в float64
+func floatVariables(vars map[string]json.Number) (map[string]float64, error) {
+	values := make(map[string]float64, len(vars))
+	for name, num := range vars {
+		val, err := num.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("неверное значение переменной %s: %s", name, num)
+		}
+		values[name] = val
+	}
+	return values, nil
+}
+
 type Config struct {
-	Addr string
+	Addr      string
+	GRPCAddr  string // порт gRPC-службы агентов; пусто — служба не запускается (GRPC_PORT=off)
+	Store     string // тип хранилища выражений: memory или bolt
+	StorePath string // путь к файлу хранилища bolt
+	// Агент, не отправлявший heartbeat дольше HeartbeatInterval * MaxMissedHeartbeats, считается отключенным
+	HeartbeatInterval   time.Duration
+	MaxMissedHeartbeats int
+	// Задача, не выполненная за LeaseTimeout, снова ставится в очередь; после MaxAttempts попыток
+	// выражение помечается ошибочным
+	LeaseTimeout time.Duration
+	MaxAttempts  int
+	// Сколько при остановке ждать результатов задач, уже выданных агентам
+	ShutdownGrace time.Duration
+	// Доставка завершенных выражений на callback_url: ключ подписи HMAC-SHA256 (без него callback_url
+	// не принимается), число попыток, пауза перед первым повтором (дальше удваивается) и таймаут запроса
+	WebhookSecret      string
+	WebhookMaxAttempts int
+	WebhookBackoff     time.Duration
+	WebhookTimeout     time.Duration
+	// Разрешить callback_url на localhost и во внутренней сети (для разработки и тестов)
+	WebhookAllowPrivate bool
 }
 
 func ConfigFromEnv() *Config {
 	config := &Config{
-		Addr: os.Getenv("PORT"),
+		Addr:      os.Getenv("PORT"),
+		GRPCAddr:  os.Getenv("GRPC_PORT"),
+		Store:     os.Getenv("STORE"),
+		StorePath: os.Getenv("STORE_PATH"),
+	}
+	if ms, err := strconv.Atoi(os.Getenv("HEARTBEAT_INTERVAL_MS")); err == nil {
+		config.HeartbeatInterval = time.Duration(ms) * time.Millisecond
+	}
+	if missed, err := strconv.Atoi(os.Getenv("HEARTBEAT_MISSED")); err == nil {
+		config.MaxMissedHeartbeats = missed
 	}
+	if ms, err := strconv.Atoi(os.Getenv("TASK_LEASE_TIMEOUT_MS")); err == nil {
+		config.LeaseTimeout = time.Duration(ms) * time.Millisecond
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("TASK_MAX_ATTEMPTS")); err == nil {
+		config.MaxAttempts = attempts
+	}
+	if ms, err := strconv.Atoi(os.Getenv("SHUTDOWN_GRACE_MS")); err == nil {
+		config.ShutdownGrace = time.Duration(ms) * time.Millisecond
+	}
+	config.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil {
+		config.WebhookMaxAttempts = attempts
+	}
+	if ms, err := strconv.Atoi(os.Getenv("WEBHOOK_BACKOFF_MS")); err == nil {
+		config.WebhookBackoff = time.Duration(ms) * time.Millisecond
+	}
+	if ms, err := strconv.Atoi(os.Getenv("WEBHOOK_TIMEOUT_MS")); err == nil {
+		config.WebhookTimeout = time.Duration(ms) * time.Millisecond
+	}
+	config.WebhookAllowPrivate, _ = strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE"))
 	if config.Addr == "" {
 		config.Addr = "8080"
 	}
+	switch config.GRPCAddr {
+	case "":
+		config.GRPCAddr = "9090"
+	case "off":
+		config.GRPCAddr = ""
+	}
+	if config.Store == "" {
+		config.Store = "memory"
+	}
+	if config.StorePath == "" {
+		config.StorePath = "expressions.db"
+	}
 	return config
 }
 
+// Application — сервер калькулятора: хранилище выражений, очередь задач и HTTP-обработчики
 type Application struct {
-	config *Config
+	config       *Config
+	expressions  storage.ExpressionStore
+	ownStore     bool // хранилище открыто самим сервером и закрывается в Close
+	queueSize    int
+	orchestrator *orchestrator.Orchestrator
+	tasksMutex   sync.Mutex
+	taskNodes    map[string]*taskNode // задачи, результат которых еще не получен
+	queued       map[string]struct{}  // выражения в статусе pending: ни одна их задача еще не выдана агенту
+	planned      map[string]string    // все задачи, запланированные с запуска сервера: ID задачи -> ID выражения
+	events       *eventHub
+	webhooks     *http.Client
+	stop         chan struct{}   // закрывается в Close, останавливает фоновые горутины
+	drainCtx     context.Context // отменяется в Drain: новые выражения и задачи не выдаются
+	startDrain   context.CancelFunc
+	closeOnce    sync.Once
+	// Доставки на callback_url, которые дожидается Close. deliveryMutex не дает запустить
+	// доставку, пока Close закрывает stop
+	deliveries    sync.WaitGroup
+	deliveryMutex sync.Mutex
+	now           func() time.Time
+	newID         func() string
+}
+
+// Option настраивает Application при создании
+type Option func(*Application)
+
+// WithConfig задает конфигурацию вместо чтения из переменных окружения
+func WithConfig(config *Config) Option {
+	return func(a *Application) { a.config = config }
+}
+
+// WithStore задает хранилище выражений. Хранилище не закрывается в Close
+func WithStore(store storage.ExpressionStore) Option {
+	return func(a *Application) { a.expressions = store }
 }
 
-func New() *Application {
-	return &Application{
-		config: ConfigFromEnv(),
+// WithQueueSize задает размер очереди задач
+func WithQueueSize(size int) Option {
+	return func(a *Application) { a.queueSize = size }
+}
+
+// WithClock задает источник текущего времени
+func WithClock(now func() time.Time) Option {
+	return func(a *Application) { a.now = now }
+}
+
+// WithIDGenerator задает генератор ID выражений и задач
+func WithIDGenerator(newID func() string) Option {
+	return func(a *Application) { a.newID = newID }
+}
+
+// New создает сервер. Если хранилище не задано через WithStore, оно открывается по конфигурации.
+// Выражения, не успевшие вычислиться до перезапуска, снова ставятся в очередь
+func New(opts ...Option) (*Application, error) {
+	a := &Application{
+		queueSize: defaultQueueSize,
+		taskNodes: make(map[string]*taskNode),
+		queued:    make(map[string]struct{}),
+		planned:   make(map[string]string),
+		events:    newEventHub(),
+		stop:      make(chan struct{}),
+		now:       time.Now,
+		newID:     generateUniqueID,
+	}
+	a.drainCtx, a.startDrain = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.config == nil {
+		a.config = ConfigFromEnv()
 	}
+	a.webhooks = newWebhookClient(cmp.Or(a.config.WebhookTimeout, defaultWebhookTimeout), a.config.WebhookAllowPrivate)
+	a.orchestrator = orchestrator.New(orchestrator.Config{
+		QueueSize:           a.queueSize,
+		HeartbeatInterval:   a.config.HeartbeatInterval,
+		MaxMissedHeartbeats: a.config.MaxMissedHeartbeats,
+		LeaseTimeout:        a.config.LeaseTimeout,
+		MaxAttempts:         a.config.MaxAttempts,
+		Now:                 a.now,
+		OnLease:             a.taskLeased,
+		Stale:               a.staleTask,
+	})
+
+	if a.expressions == nil {
+		store, err := storage.Open(a.config.Store, a.config.StorePath)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при открытии хранилища: %w", err)
+		}
+		a.expressions = store
+		a.ownStore = true
+	}
+	if err := a.recoverExpressions(); err != nil {
+		a.Close()
+		return nil, fmt.Errorf("ошибка при восстановлении выражений: %w", err)
+	}
+	go a.watchLeases()
+	return a, nil
+}
+
+// Close останавливает фоновые горутины и закрывает хранилище, открытое сервером
+func (a *Application) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		a.deliveryMutex.Lock()
+		close(a.stop)
+		a.deliveryMutex.Unlock()
+		// Доставки прерываются по stop; дожидаемся их, чтобы они не писали в закрытое хранилище
+		a.deliveries.Wait()
+		a.orchestrator.Close()
+		if a.ownStore {
+			err = a.expressions.Close()
+		}
+	})
+	return err
 }
 
-func AddExpressionHandler(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Expression string `json:"expression"`
+// Handler возвращает HTTP-обработчик со всеми маршрутами сервера
+func (a *Application) Handler() http.Handler {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/api/v1/calculate", a.AddExpressionHandler).Methods("POST")
+	r.HandleFunc("/api/v1/calculate/batch", a.AddBatchHandler).Methods("POST")
+	r.HandleFunc("/api/v1/batches/{id}", a.GetBatchHandler).Methods("GET")
+	r.HandleFunc("/api/v1/expressions", a.GetExpressionsHandler).Methods("GET")
+	r.HandleFunc("/api/v1/expressions/{id}", a.GetExpressionByIDHandler).Methods("GET")
+	r.HandleFunc("/api/v1/expressions/{id}", a.CancelExpressionHandler).Methods("DELETE")
+	r.HandleFunc("/api/v1/expressions/{id}/events", a.ExpressionEventsHandler).Methods("GET")
+	r.HandleFunc("/api/v1/expressions/{id}/ws", a.ExpressionWebSocketHandler).Methods("GET")
+	r.HandleFunc("/internal/task", a.GetTaskHandler).Methods("GET")
+	r.HandleFunc("/internal/result", a.ReceiveResultHandler).Methods("POST")
+	r.HandleFunc("/internal/agents", a.orchestrator.RegisterAgentHandler).Methods("POST")
+	r.HandleFunc("/internal/agents/{id}/heartbeat", a.orchestrator.HeartbeatHandler).Methods("POST")
+	r.HandleFunc("/internal/agents/{id}", a.orchestrator.DeregisterAgentHandler).Methods("DELETE")
+	r.HandleFunc("/internal/agents", a.orchestrator.ListAgentsHandler).Methods("GET")
+	r.HandleFunc("/api/v1/agents", a.orchestrator.ListAgentsHandler).Methods("GET")
+
+	return r
+}
+
+// expressionRequest — выражение в запросе POST /api/v1/calculate и элемент пакета в POST /api/v1/calculate/batch
+type expressionRequest struct {
+	ClientID    string                 `json:"client_id"` // только в пакете
+	Expression  string                 `json:"expression"`
+	Variables   map[string]json.Number `json:"variables"`
+	Precision   string                 `json:"precision"`
+	CallbackURL string                 `json:"callback_url"`
+}
+
+// requestError — ошибка в параметрах запроса, а не в самом выражении
+type requestError string
+
+func (e requestError) Error() string { return string(e) }
+
+func (a *Application) AddExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	if a.drainCtx.Err() != nil {
+		http.Error(w, "Сервер останавливается", http.StatusServiceUnavailable)
+		return
 	}
+
+	var req expressionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
 		return
 	}
 
-	numbers, operators, err := parseExpression(req.Expression)
+	expr, graph, ready, err := a.prepareExpression(req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeExpressionError(w, err)
 		return
 	}
-
-	expressionID := generateUniqueID()
-
-	expr := &models.Expression{
-		ID:         expressionID,
-		Expression: req.Expression,
-		Status:     "pending",
+	if len(ready) > a.orchestrator.Free() {
+		http.Error(w, "Очередь задач переполнена", http.StatusServiceUnavailable)
+		return
+	}
+	if err := a.saveExpression(expr, graph); err != nil {
+		http.Error(w, "Ошибка при сохранении выражения", http.StatusInternalServerError)
+		return
 	}
 
-	expressionsMutex.Lock()
-	expressions[expressionID] = expr
-	expressionsMutex.Unlock()
+	// Место в очереди могли занять другие запросы после проверки выше. Выражение уже
+	// сохранено, поэтому не поместившиеся задачи ставятся в очередь в фоне
+	a.orchestrator.Enqueue(ready)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": expr.ID})
+}
 
-	task := models.Task{
-		ID:        expressionID,
-		Numbers:   numbers,
-		Operators: operators,
+// prepareExpression проверяет запрос, разбирает выражение и планирует его задачи.
+// Возвращает выражение, граф его задач и задачи, которые можно вычислять сразу.
+// Выражение без операций возвращается уже вычисленным. Неверные параметры запроса
+// возвращаются как requestError, ошибки самого выражения — как ошибки calculation
+func (a *Application) prepareExpression(req expressionRequest) (models.Expression, map[string]*taskNode, []models.Task, error) {
+	if req.Precision == "" {
+		req.Precision = models.PrecisionFloat
+	}
+	if req.Precision != models.PrecisionFloat && req.Precision != models.PrecisionDecimal {
+		return models.Expression{}, nil, nil, requestError("Неподдерживаемый режим точности: " + req.Precision)
+	}
+	if req.CallbackURL != "" {
+		if err := a.checkCallbackURL(req.CallbackURL); err != nil {
+			return models.Expression{}, nil, nil, err
+		}
 	}
 
-	select {
-	case tasks <- task:
-		log.Printf("Задача с ID %s добавлена в очередь", expressionID)
-		expressionsMutex.Lock()
-		expr.Status = "processing"
-		expressionsMutex.Unlock()
-	default:
-		http.Error(w, "Очередь задач переполнена", http.StatusServiceUnavailable)
-		return
+	parsed, err := calculation.Parse(req.Expression)
+	if err != nil {
+		return models.Expression{}, nil, nil, err
+	}
+	vars, err := floatVariables(req.Variables)
+	if err != nil {
+		return models.Expression{}, nil, nil, requestError(err.Error())
+	}
+	if missing := calculation.MissingVariables(parsed, vars); len(missing) > 0 {
+		return models.Expression{}, nil, nil, &calculation.UnboundVariablesError{Names: missing}
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": expressionID})
+	expr := models.Expression{
+		ID:          a.newID(),
+		Expression:  req.Expression,
+		Variables:   req.Variables,
+		Precision:   req.Precision,
+		Status:      models.StatusPending,
+		CallbackURL: req.CallbackURL,
+		CreatedAt:   a.now(),
+	}
+	graph, value, ready := a.planTasks(expr.ID, parsed.Root, req.Variables, req.Precision)
+	if len(graph) == 0 {
+		// Выражение без операций вычислять не нужно
+		expr.Status = models.StatusCompleted
+		expr.Result, expr.Decimal = finalResult(req.Precision, value.value, value.decimal.RatString())
+		expr.CompletedAt = expr.CreatedAt
+	}
+	return expr, graph, ready, nil
 }
 
-func GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	expressionsMutex.Lock()
-	defer expressionsMutex.Unlock()
+// saveExpression сохраняет выражение и начинает ждать результаты его задач
+func (a *Application) saveExpression(expr models.Expression, graph map[string]*taskNode) error {
+	if err := a.expressions.Create(expr); err != nil {
+		log.Printf("Ошибка при сохранении выражения %s: %v", expr.ID, err)
+		return err
+	}
+	a.registerTasks(expr.ID, graph)
+	if expr.Status == models.StatusCompleted {
+		a.notify(expr.ID)
+	}
+	return nil
+}
 
-	expressionList := make([]models.Expression, 0, len(expressions))
-	for _, expr := range expressions {
-		expressionList = append(expressionList, *expr)
+// GetExpressionsHandler возвращает выражения страницами в порядке создания. Параметры: limit — размер
+// страницы, cursor — next_cursor предыдущей страницы, status — только выражения в этом статусе,
+// created_from и created_to (RFC 3339) — интервал времени создания, order=desc — сначала новые
+func (a *Application) GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := listQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := a.expressions.ListPage(query)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		http.Error(w, "Неверный курсор", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при чтении выражений", http.StatusInternalServerError)
+		return
 	}
 
+	response := map[string]interface{}{"expressions": page.Expressions}
+	if page.Expressions == nil {
+		response["expressions"] = []models.Expression{}
+	}
+	if page.NextCursor != "" {
+		response["next_cursor"] = page.NextCursor
+	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"expressions": expressionList,
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
-func GetExpressionByIDHandler(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
+// listQuery разбирает параметры запроса GET /api/v1/expressions
+func listQuery(params url.Values) (storage.ListQuery, error) {
+	query := storage.ListQuery{Status: params.Get("status"), Cursor: params.Get("cursor"), Limit: defaultPageSize}
+	switch query.Status {
+	case "", models.StatusPending, models.StatusProcessing, models.StatusCompleted, models.StatusFailed, models.StatusCancelled:
+	default:
+		return query, fmt.Errorf("Неизвестный статус: %s", query.Status)
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, fmt.Errorf("Неверный limit: нужно число от 1 до %d", maxPageSize)
+		}
+		query.Limit = limit
+	}
+	for param, field := range map[string]*time.Time{"created_from": &query.CreatedFrom, "created_to": &query.CreatedTo} {
+		if value := params.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return query, fmt.Errorf("Неверное время %s: нужен формат RFC 3339", param)
+			}
+			*field = t
+		}
+	}
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("Неверный порядок: нужен asc или desc")
+	}
+	return query, nil
+}
 
-	expressionsMutex.Lock()
-	defer expressionsMutex.Unlock()
+func (a *Application) GetExpressionByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
 
-	expr, found := expressions[id]
-	if !found {
+	expr, err := a.expressions.Get(id)
+	if errors.Is(err, storage.ErrNotFound) {
 		http.Error(w, "Выражение не найдено", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Ошибка при чтении выражения", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(expr)
 }
 
-func GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-	select {
-	case task := <-tasks:
+// GetTaskHandler выдает агенту задачу из очереди вместе с ключом аренды lease_token: результат
+// принимается только с ним. Агент передает свой ID в параметре agent_id, тогда задача учитывается
+// в его нагрузке, если он зарегистрирован.
+// С параметром wait (например, wait=30s) при пустой очереди запрос ждет задачу до wait
+// и возвращает 204, если она так и не появилась; без него сразу возвращается 404
+func (a *Application) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if a.drainCtx.Err() != nil {
+		http.Error(w, "Сервер останавливается", http.StatusServiceUnavailable)
+		return
+	}
+	agentID := r.URL.Query().Get("agent_id")
+
+	waitParam := r.URL.Query().Get("wait")
+	if waitParam == "" {
+		task, ok := a.orchestrator.Next(agentID)
+		if !ok {
+			http.Error(w, "Нет доступных задач", http.StatusNotFound)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(task)
-	default:
-		http.Error(w, "Нет доступных задач", http.StatusNotFound)
+		return
+	}
+
+	wait, err := time.ParseDuration(waitParam)
+	if err != nil || wait < 0 {
+		http.Error(w, "Неверное время ожидания: "+waitParam, http.StatusBadRequest)
+		return
+	}
+
+	task, ok := a.waitTask(r.Context(), agentID, wait)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(task)
+}
+
+// waitTask выдает агенту задачу, при пустой очереди ожидая ее не дольше wait (но не дольше maxTaskWait).
+// Ожидание прерывается по таймауту, отменой ctx и при остановке сервера
+func (a *Application) waitTask(ctx context.Context, agentID string, wait time.Duration) (models.Task, bool) {
+	if wait > maxTaskWait {
+		wait = maxTaskWait
 	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	stopOnDrain := context.AfterFunc(a.drainCtx, cancel)
+	defer stopOnDrain()
+
+	return a.orchestrator.NextWait(ctx, agentID)
 }
 
-func ReceiveResultHandler(w http.ResponseWriter, r *http.Request) {
+func (a *Application) ReceiveResultHandler(w http.ResponseWriter, r *http.Request) {
 	var result models.Result
 	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
 		http.Error(w, "Invalid payload", http.StatusBadRequest)
 		return
 	}
 
-	expressionsMutex.Lock()
-	defer expressionsMutex.Unlock()
+	switch err := a.acceptResult(result); {
+	case errors.Is(err, ErrUnknownTask):
+		http.Error(w, "Задача не найдена", http.StatusNotFound)
+	case errors.Is(err, orchestrator.ErrLeaseMismatch):
+		http.Error(w, "Задача не выдана этому агенту или аренда истекла", http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-	if expr, ok := expressions[result.ID]; ok {
-		expr.Status = "completed"
-		expr.Result = result.Result
-		log.Printf("Updated expression %s: result=%f", result.ID, result.Result)
+// acceptResult проверяет аренду задачи, снимает ее, сохраняет результат и ставит в очередь задачу,
+// которая стала готова к вычислению. Результат из чужой или истекшей аренды отклоняется
+// с orchestrator.ErrLeaseMismatch. Прием идемпотентен: результат задачи, которую сервер больше
+// не ждет, подтверждается и ничего не меняет. Результат задачи, которую сервер не планировал
+// (или другого выражения, если указан expression_id), отклоняется с ErrUnknownTask
+func (a *Application) acceptResult(result models.Result) error {
+	a.tasksMutex.Lock()
+	if !a.awaiting(result.ID) {
+		expressionID, planned := a.planned[result.ID]
+		a.tasksMutex.Unlock()
+		if !planned || result.ExpressionID != "" && result.ExpressionID != expressionID {
+			return ErrUnknownTask
+		}
+		// Результат задачи уже получен или выражение завершилось без нее. Агент мог получить
+		// копию задачи, выданную повторно: ее аренда больше не нужна
+		a.orchestrator.Release(result.ID, result.LeaseToken)
+		log.Printf("Результат задачи с ID %s уже получен или больше не нужен, повтор проигнорирован", result.ID)
+		return nil
 	}
+	if err := a.orchestrator.Release(result.ID, result.LeaseToken); err != nil {
+		a.tasksMutex.Unlock()
+		log.Printf("Результат задачи с ID %s отклонен: %v", result.ID, err)
+		return err
+	}
+	next, ok := a.completeTask(result)
+	a.tasksMutex.Unlock()
 
-	w.WriteHeader(http.StatusOK)
+	// Отправляем в очередь вне блокировки. При полной очереди задача ставится в фоне:
+	// иначе агент, приславший результат, ждал бы, пока очередь разберут, в том числе он сам
+	if ok {
+		a.orchestrator.Enqueue([]models.Task{next})
+	}
+	return nil
 }
 
-func parseExpression(expr string) ([]float64, []string, error) {
-	// Разделяем выражение на числа и операторы
-	parts := strings.Fields(expr)
-	if len(parts) < 3 || len(parts)%2 == 0 {
-		return nil, nil, fmt.Errorf("неверный формат выражения")
+// awaiting сообщает, ждет ли сервер результат задачи. Вызывается под tasksMutex
+func (a *Application) awaiting(taskID string) bool {
+	_, ok := a.taskNodes[taskID]
+	return ok
+}
+
+// completeTask сохраняет результат задачи и подставляет его в зависящую от нее задачу.
+// Возвращает задачу, которая стала готова к вычислению. Вызывается под tasksMutex.
+func (a *Application) completeTask(result models.Result) (models.Task, bool) {
+	tn, ok := a.taskNodes[result.ID]
+	if !ok {
+		return models.Task{}, false
 	}
+	delete(a.taskNodes, result.ID)
 
-	var numbers []float64
-	var operators []string
+	if result.ErrorCode != "" || result.Error != "" {
+		// Выражение уже не вычислить: остальные его задачи не нужны
+		a.forgetExpression(tn.task.ExpressionID)
+		a.saveFailure(tn.task.ExpressionID, result.ErrorCode, result.Error)
+		return models.Task{}, false
+	}
+	a.publishTask(tn.task, result)
 
-	for i, part := range parts {
-		if i%2 == 0 {
-			// Это число
-			num, err := strconv.ParseFloat(part, 64)
+	if tn.parent == "" {
+		value, decimal := finalResult(tn.task.Precision, result.Result, result.Decimal)
+		if err := a.expressions.SetResult(tn.task.ExpressionID, value, decimal, a.now()); err != nil {
+			log.Printf("Ошибка при сохранении результата выражения %s: %v", tn.task.ExpressionID, err)
+		} else {
+			log.Printf("Updated expression %s: result=%f", tn.task.ExpressionID, value)
+			a.publishExpression(tn.task.ExpressionID)
+			a.notify(tn.task.ExpressionID)
+		}
+		return models.Task{}, false
+	}
+
+	parent, ok := a.taskNodes[tn.parent]
+	if !ok {
+		return models.Task{}, false
+	}
+	parent.setArg(tn.slot, result)
+	parent.pending--
+	if parent.pending > 0 {
+		return models.Task{}, false
+	}
+	return parent.task, true
+}
+
+// watchLeases периодически возвращает в очередь задачи, агенты которых не прислали результат вовремя
+func (a *Application) watchLeases() {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.expireLeases()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// expireLeases возвращает в очередь задачи с истекшей арендой. Если задача исчерпала
+// все попытки, ее выражение помечается ошибочным
+func (a *Application) expireLeases() {
+	for _, task := range a.orchestrator.ExpireLeases() {
+		a.tasksMutex.Lock()
+		// Если задачу уже не ждут, результат прислал агент, получивший ее раньше, или выражение отменено
+		if a.awaiting(task.ID) {
+			a.failExpression(task.ExpressionID, CodeAttemptsExhausted, fmt.Sprintf("задача %s не выполнена за %d попыток: агент не вернул результат", task.ID, task.Attempt))
+		}
+		a.tasksMutex.Unlock()
+	}
+}
+
+// failExpression переводит выражение в статус failed и забывает его оставшиеся задачи.
+// Вызывается под tasksMutex, чтобы отмена не вклинилась между ними
+func (a *Application) failExpression(expressionID, code, message string) {
+	if a.forgetExpression(expressionID) == 0 {
+		// Выражение без ожидающих задач уже завершено
+		return
+	}
+	a.saveFailure(expressionID, code, message)
+}
+
+// forgetExpression удаляет задачи выражения из ожидающих результата и возвращает их число.
+// Вызывается под tasksMutex
+func (a *Application) forgetExpression(expressionID string) int {
+	n := 0
+	for id, tn := range a.taskNodes {
+		if tn.task.ExpressionID == expressionID {
+			delete(a.taskNodes, id)
+			n++
+		}
+	}
+	delete(a.queued, expressionID)
+	return n
+}
+
+// saveFailure сохраняет ошибку выражения; пустой код заменяется на calculation.CodeUnknown
+func (a *Application) saveFailure(expressionID, code, message string) {
+	if code == "" {
+		code = calculation.CodeUnknown
+	}
+	if err := a.expressions.SetFailed(expressionID, code, message, a.now()); err != nil {
+		log.Printf("Ошибка при сохранении ошибки выражения %s: %v", expressionID, err)
+		return
+	}
+	log.Printf("Выражение %s завершилось ошибкой %s: %s", expressionID, code, message)
+	a.publishExpression(expressionID)
+	a.notify(expressionID)
+}
+
+// registerTasks добавляет граф задач выражения к задачам, ожидающим результата
+func (a *Application) registerTasks(expressionID string, graph map[string]*taskNode) {
+	a.tasksMutex.Lock()
+	defer a.tasksMutex.Unlock()
+	for id, tn := range graph {
+		a.taskNodes[id] = tn
+		a.planned[id] = expressionID
+	}
+	if len(graph) > 0 {
+		a.queued[expressionID] = struct{}{}
+	}
+}
+
+// taskLeased переводит выражение в статус processing, когда агент получает первую его задачу.
+// Вызывается оркестратором до того, как задача попадет к агенту
+func (a *Application) taskLeased(task models.Task) {
+	a.tasksMutex.Lock()
+	_, queued := a.queued[task.ExpressionID]
+	delete(a.queued, task.ExpressionID)
+	a.tasksMutex.Unlock()
+	if !queued {
+		return
+	}
+	err := a.expressions.UpdateStatus(task.ExpressionID, models.StatusProcessing)
+	if errors.Is(err, storage.ErrFinished) {
+		// Выражение отменили, пока задача выдавалась агенту
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка при обновлении статуса выражения %s: %v", task.ExpressionID, err)
+		return
+	}
+	a.publishExpression(task.ExpressionID)
+}
+
+// recoverExpressions заново планирует выражения, которые не успели вычислиться до перезапуска
+// сервера. Промежуточные результаты не сохраняются, поэтому выражения вычисляются с начала.
+// Готовые задачи отправляются в очередь в фоне: агенты начнут их разбирать после запуска сервера
+func (a *Application) recoverExpressions() error {
+	list, err := a.expressions.List()
+	if err != nil {
+		return err
+	}
+
+	var queue []models.Task
+	for _, expr := range list {
+		if isFinal(expr.Status) {
+			if undelivered(expr, a.webhookAttempts()) {
+				// Доставка прервалась перезапуском: продолжаем с того же номера попытки
+				a.startDelivery(expr)
+			}
+			continue
+		}
+		if expr.Status != models.StatusPending && expr.Status != models.StatusProcessing {
+			continue
+		}
+		parsed, err := calculation.Parse(expr.Expression)
+		if err != nil {
+			log.Printf("Не удалось восстановить выражение %s: %v", expr.ID, err)
+			continue
+		}
+		graph, _, ready := a.planTasks(expr.ID, parsed.Root, expr.Variables, expr.Precision)
+		a.registerTasks(expr.ID, graph)
+		queue = append(queue, ready...)
+		log.Printf("Выражение %s восстановлено после перезапуска", expr.ID)
+	}
+
+	a.orchestrator.Enqueue(queue)
+	return nil
+}
+
+// planTasks разбивает дерево выражения на задачи, по одной на каждую бинарную операцию
+// и вызов функции. Переменные заменяются значениями из vars.
+// Возвращает граф задач, значение выражения (если в нем нет операций)
+// и задачи, которые можно вычислять сразу.
+func (a *Application) planTasks(expressionID string, root calculation.Node, vars map[string]json.Number, precision string) (map[string]*taskNode, operand, []models.Task) {
+	graph := make(map[string]*taskNode)
+	var ready []models.Task
+
+	// newTask добавляет задачу в граф; аргументы-ссылки заполнятся результатами других задач
+	newTask := func(task models.Task, args []operand) operand {
+		task.ID = a.newID()
+		task.ExpressionID = expressionID
+		if precision == models.PrecisionDecimal {
+			task.Precision = precision
+			task.DecimalArgs = make([]string, len(args))
+		}
+		tn := &taskNode{task: task}
+		graph[task.ID] = tn
+
+		for i, arg := range args {
+			if arg.ref != "" {
+				graph[arg.ref].parent = task.ID
+				graph[arg.ref].slot = i + 1
+				tn.pending++
+				continue
+			}
+			if precision == models.PrecisionDecimal {
+				tn.task.DecimalArgs[i] = arg.decimal.RatString()
+			}
+		}
+		if tn.pending == 0 {
+			ready = append(ready, tn.task)
+		}
+		return operand{ref: task.ID}
+	}
+
+	var visit func(n calculation.Node) operand
+	visit = func(n calculation.Node) operand {
+		switch n := n.(type) {
+		case *calculation.NumberLit:
+			exact, err := calculation.ParseDecimal(n.Text)
 			if err != nil {
-				return nil, nil, fmt.Errorf("неверное число: %s", part)
+				exact = new(big.Rat).SetFloat64(n.Value)
 			}
-			numbers = append(numbers, num)
-		} else {
-			// Это оператор
-			if part != "+" && part != "-" && part != "*" && part != "/" {
-				return nil, nil, fmt.Errorf("неподдерживаемый оператор: %s", part)
+			return operand{value: n.Value, decimal: exact}
+		case *calculation.VarRef:
+			value, _ := vars[n.Name].Float64()
+			exact, _ := calculation.ParseDecimal(string(vars[n.Name]))
+			return operand{value: value, decimal: exact}
+		case *calculation.GroupExpr:
+			return visit(n.Inner)
+		case *calculation.UnaryExpr:
+			arg := visit(n.Operand)
+			if n.Op == "+" {
+				return arg
+			}
+			if arg.ref == "" {
+				return operand{value: -arg.value, decimal: new(big.Rat).Neg(arg.decimal)}
+			}
+			// Отрицание вычисляемого подвыражения превращаем в задачу 0 - x
+			zero := operand{decimal: new(big.Rat)}
+			return newTask(models.Task{Type: models.TaskOperation, Operation: "-"}, []operand{zero, arg})
+		case *calculation.BinaryExpr:
+			left, right := visit(n.Left), visit(n.Right)
+			return newTask(models.Task{Type: models.TaskOperation, Operation: n.Op, Arg1: left.value, Arg2: right.value}, []operand{left, right})
+		case *calculation.CallExpr:
+			args := make([]operand, len(n.Args))
+			values := make([]float64, len(n.Args))
+			for i, arg := range n.Args {
+				args[i] = visit(arg)
+				values[i] = args[i].value
 			}
-			operators = append(operators, part)
+			return newTask(models.Task{Type: models.TaskFunction, Function: n.Name, Args: values}, args)
 		}
+		return operand{decimal: new(big.Rat)}
 	}
 
-	return numbers, operators, nil
+	return graph, visit(root), ready
+}
+
+// writeExpressionError отправляет клиенту ошибку выражения или его параметров в формате JSON
+func writeExpressionError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(expressionError(err))
+}
+
+// expressionError описывает ошибку выражения для ответа клиенту
+func expressionError(err error) *models.ExpressionError {
+	response := &models.ExpressionError{Error: err.Error(), ErrorCode: calculation.ErrorCode(err)}
+	var rerr requestError
+	if errors.As(err, &rerr) {
+		response.ErrorCode = CodeInvalidRequest
+	}
+	var serr *calculation.SyntaxError
+	if errors.As(err, &serr) {
+		response.Error = serr.Msg
+		response.Position = &serr.Pos
+	}
+	var uerr *calculation.UnboundVariablesError
+	if errors.As(err, &uerr) {
+		response.MissingVariables = uerr.Names
+	}
+	return response
 }
 
 func generateUniqueID() string {
 	return uuid.New().String()
 }
 
-func (a *Application) RunServer() error {
-	r := mux.NewRouter()
+// RunServer обслуживает HTTP-запросы до отмены ctx, после чего плавно останавливает сервер:
+// перестает принимать выражения, ждет результаты выданных задач не дольше ShutdownGrace
+// и закрывает хранилище
+func (a *Application) RunServer(ctx context.Context) error {
+	defer a.Close()
+
+	srv := &http.Server{Addr: ":" + a.config.Addr, Handler: a.Handler()}
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	log.Printf("Сервер запущен на порту %s\n", a.config.Addr)
 
-	r.HandleFunc("/api/v1/calculate", AddExpressionHandler).Methods("POST")
-	r.HandleFunc("/api/v1/expressions", GetExpressionsHandler).Methods("GET")
-	r.HandleFunc("/api/v1/expressions/{id}", GetExpressionByIDHandler).Methods("GET")
-	r.HandleFunc("/internal/task", GetTaskHandler).Methods("GET")
-	r.HandleFunc("/internal/result", ReceiveResultHandler).Methods("POST")
+	var grpcSrv *grpc.Server
+	if a.config.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", ":"+a.config.GRPCAddr)
+		if err != nil {
+			srv.Close()
+			return fmt.Errorf("ошибка при запуске gRPC-службы: %w", err)
+		}
+		grpcSrv = a.GRPCServer()
+		go func() {
+			errCh <- grpcSrv.Serve(lis)
+		}()
+		log.Printf("gRPC-служба агентов запущена на порту %s\n", a.config.GRPCAddr)
+	}
 
-	log.Printf("Сервер запущен на порту %s\n", a.config.Addr)
-	return http.ListenAndServe(":"+a.config.Addr, r)
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	grace := a.config.ShutdownGrace
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	log.Println("Остановка сервера...")
+	if err := a.Drain(shutdownCtx); err != nil {
+		log.Printf("Не все выданные задачи завершены: %v", err)
+	}
+	if grpcSrv != nil {
+		stopGRPC(shutdownCtx, grpcSrv)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("ошибка при остановке сервера: %w", err)
+	}
+	log.Println("Сервер остановлен")
+	return nil
+}
+
+// Drain перестает принимать новые выражения и выдавать задачи и ждет, пока агенты вернут
+// результаты уже выданных задач или отменится ctx. Результаты по-прежнему принимаются.
+// Невыполненные выражения будут вычислены заново после перезапуска
+func (a *Application) Drain(ctx context.Context) error {
+	a.startDrain()
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for {
+		leased := a.orchestrator.Leased()
+		if leased == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("осталось задач у агентов: %d: %w", leased, ctx.Err())
+		}
+	}
 }
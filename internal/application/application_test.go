@@ -2,17 +2,27 @@ package application
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"Second_sprint_final_task/internal/orchestrator"
+	"Second_sprint_final_task/internal/storage"
+	"Second_sprint_final_task/pkg/calculation"
 	"Second_sprint_final_task/pkg/models"
 )
 
 func TestAddExpressionHandler(t *testing.T) {
+	app := newTestApp(t)
+
 	// Создаем тестовый запрос с выражением
 	reqBody := `{"expression": "1 + 2 - 3"}`
 	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(reqBody))
@@ -22,7 +32,7 @@ func TestAddExpressionHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Вызываем обработчик
-	AddExpressionHandler(rr, req)
+	app.AddExpressionHandler(rr, req)
 
 	// Проверяем статус код
 	if rr.Code != http.StatusCreated {
@@ -39,24 +49,81 @@ func TestAddExpressionHandler(t *testing.T) {
 	if _, ok := response["id"]; !ok {
 		t.Error("Ожидаемый ключ 'id' отсутствует в ответе")
 	}
+
+	// Сразу готова только задача 1 + 2, вычитание ждет ее результата
+	queued := drainTasks(app)
+	if len(queued) != 1 {
+		t.Fatalf("Ожидалась 1 задача в очереди, получено: %d", len(queued))
+	}
+	if queued[0].Arg1 != 1 || queued[0].Arg2 != 2 || queued[0].Operation != "+" {
+		t.Errorf("Ожидаемая задача: 1 + 2, получено: %v %s %v", queued[0].Arg1, queued[0].Operation, queued[0].Arg2)
+	}
+	if queued[0].ExpressionID != response["id"] {
+		t.Errorf("Ожидаемый ID выражения: %s, получено: %s", response["id"], queued[0].ExpressionID)
+	}
+}
+
+func TestExpressionSplitIntoParallelTasks(t *testing.T) {
+	app := newTestApp(t)
+
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 * 2 + 3 * 4"}`))
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+	var response map[string]string
+	json.NewDecoder(rr.Body).Decode(&response)
+	id := response["id"]
+
+	// Оба умножения независимы и должны попасть в очередь одновременно
+	queued := drainTasks(app)
+	if len(queued) != 2 {
+		t.Fatalf("Ожидалось 2 задачи в очереди, получено: %d", len(queued))
+	}
+	for _, task := range queued {
+		if task.Operation != "*" {
+			t.Errorf("Ожидаемая операция: *, получено: %s", task.Operation)
+		}
+		postResult(t, app, task, task.Arg1*task.Arg2)
+	}
+
+	// После обоих результатов готово сложение
+	queued = drainTasks(app)
+	if len(queued) != 1 {
+		t.Fatalf("Ожидалась 1 задача в очереди, получено: %d", len(queued))
+	}
+	sum := queued[0]
+	if sum.Operation != "+" || sum.Arg1+sum.Arg2 != 14 {
+		t.Errorf("Ожидаемая задача: 2 + 12, получено: %v %s %v", sum.Arg1, sum.Operation, sum.Arg2)
+	}
+
+	if expr, _ := app.expressions.Get(id); expr.Status != "processing" {
+		t.Errorf("Ожидаемый статус выражения: processing, получено: %s", expr.Status)
+	}
+
+	postResult(t, app, sum, sum.Arg1+sum.Arg2)
+
+	if expr, _ := app.expressions.Get(id); expr.Status != "completed" || expr.Result != 14 {
+		t.Errorf("Ожидалось завершенное выражение с результатом 14, получено: %s, %f", expr.Status, expr.Result)
+	}
 }
 
 func TestGetExpressionsHandler(t *testing.T) {
+	app := newTestApp(t)
 	// Добавляем тестовое выражение
-	expressionsMutex.Lock()
-	expressions["test-id"] = &models.Expression{
+	app.expressions.Create(models.Expression{
 		ID:         "test-id",
 		Expression: "1 + 2",
 		Status:     "pending",
-	}
-	expressionsMutex.Unlock()
+	})
 
 	// Создаем тестовый запрос
 	req := httptest.NewRequest("GET", "/api/v1/expressions", nil)
 	rr := httptest.NewRecorder()
 
 	// Вызываем обработчик
-	GetExpressionsHandler(rr, req)
+	app.GetExpressionsHandler(rr, req)
 
 	// Проверяем статус код
 	if rr.Code != http.StatusOK {
@@ -82,15 +149,86 @@ func TestGetExpressionsHandler(t *testing.T) {
 	}
 }
 
+func TestGetExpressionsHandlerPages(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	app := newTestApp(t, WithClock(func() time.Time { return now }))
+	// id-1 = 5 создано первым и сразу вычислено, id-2 = 2 + 3 и id-4 = 1 + 1 ждут агентов
+	for _, expression := range []string{"5", "2 + 3", "1 + 1"} {
+		addExpression(t, app, `{"expression": "`+expression+`"}`)
+		now = now.Add(time.Minute)
+	}
+	if expr, _ := app.expressions.Get("id-1"); !expr.CreatedAt.Equal(start) || !expr.CompletedAt.Equal(start) {
+		t.Errorf("Ожидались время создания и завершения %v, получено: %+v", start, expr)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []string // страницы выражений, пройденные по next_cursor
+	}{
+		{"Без параметров", "", http.StatusOK, []string{"id-1 id-2 id-4"}},
+		{"Страницы", "?limit=2", http.StatusOK, []string{"id-1 id-2", "id-4"}},
+		{"Сначала новые", "?limit=2&order=desc", http.StatusOK, []string{"id-4 id-2", "id-1"}},
+		{"По статусу", "?status=pending", http.StatusOK, []string{"id-2 id-4"}},
+		{"По времени создания", "?created_from=2024-01-01T00:01:00Z&created_to=2024-01-01T00:02:00Z", http.StatusOK, []string{"id-2"}},
+		{"Ничего не найдено", "?status=failed", http.StatusOK, []string{""}},
+		{"Неверный limit", "?limit=0", http.StatusBadRequest, nil},
+		{"Неверный статус", "?status=done", http.StatusBadRequest, nil},
+		{"Неверное время", "?created_from=yesterday", http.StatusBadRequest, nil},
+		{"Неверный порядок", "?order=random", http.StatusBadRequest, nil},
+		{"Неверный курсор", "?cursor=!!!", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			url := "/api/v1/expressions" + tt.query
+			for {
+				rr := httptest.NewRecorder()
+				app.GetExpressionsHandler(rr, httptest.NewRequest("GET", url, nil))
+				if rr.Code != tt.wantStatus {
+					t.Fatalf("Ожидаемый статус код: %d, получено: %d", tt.wantStatus, rr.Code)
+				}
+				if rr.Code != http.StatusOK {
+					return
+				}
+				var response struct {
+					Expressions []models.Expression `json:"expressions"`
+					NextCursor  string              `json:"next_cursor"`
+				}
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil || response.Expressions == nil {
+					t.Fatalf("Неверный ответ: %+v, ошибка: %v", response, err)
+				}
+				var ids []string
+				for _, expr := range response.Expressions {
+					ids = append(ids, expr.ID)
+				}
+				got = append(got, strings.Join(ids, " "))
+				if response.NextCursor == "" || len(got) > len(tt.want) {
+					break
+				}
+				sep := "?"
+				if tt.query != "" {
+					sep = "&"
+				}
+				url = "/api/v1/expressions" + tt.query + sep + "cursor=" + response.NextCursor
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("Ожидаемые страницы: %q, получено: %q", tt.want, got)
+			}
+		})
+	}
+}
+
 func TestGetExpressionByIDHandler(t *testing.T) {
+	app := newTestApp(t)
 	// Добавляем тестовое выражение
-	expressionsMutex.Lock()
-	expressions["test-id"] = &models.Expression{
+	app.expressions.Create(models.Expression{
 		ID:         "test-id",
 		Expression: "1 + 2",
 		Status:     "pending",
-	}
-	expressionsMutex.Unlock()
+	})
 
 	// Создаем тестовый запрос
 	req := httptest.NewRequest("GET", "/api/v1/expressions/test-id", nil)
@@ -98,7 +236,7 @@ func TestGetExpressionByIDHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Вызываем обработчик
-	GetExpressionByIDHandler(rr, req)
+	app.GetExpressionByIDHandler(rr, req)
 
 	// Проверяем статус код
 	if rr.Code != http.StatusOK {
@@ -118,20 +256,25 @@ func TestGetExpressionByIDHandler(t *testing.T) {
 }
 
 func TestGetTaskHandler(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	app := newTestApp(t, WithClock(func() time.Time { return now }))
+
 	// Добавляем тестовую задачу в канал
 	task := models.Task{
 		ID:        generateUniqueID(), // Используем динамический ID
-		Numbers:   []float64{1, 2},
-		Operators: []string{"+"},
+		Arg1:      1,
+		Arg2:      2,
+		Operation: "+",
 	}
-	tasks <- task
+	app.registerTasks("", map[string]*taskNode{task.ID: {task: task}})
+	app.orchestrator.Submit(task)
 
 	// Создаем тестовый запрос
-	req := httptest.NewRequest("GET", "/internal/task", nil)
+	req := httptest.NewRequest("GET", "/internal/task?agent_id=agent1", nil)
 	rr := httptest.NewRecorder()
 
 	// Вызываем обработчик
-	GetTaskHandler(rr, req)
+	app.GetTaskHandler(rr, req)
 
 	// Проверяем статус код
 	if rr.Code != http.StatusOK {
@@ -144,29 +287,36 @@ func TestGetTaskHandler(t *testing.T) {
 		t.Fatalf("Ошибка при декодировании ответа: %v", err)
 	}
 
-	// Проверяем, что возвращена правильная задача (без учета ID)
-	if !equalSlices(returnedTask.Numbers, task.Numbers) {
-		t.Errorf("Ожидаемые числа: %v, получено: %v", task.Numbers, returnedTask.Numbers)
+	// Проверяем, что возвращена правильная задача, выданная в аренду
+	if returnedTask.LeaseToken == "" {
+		t.Error("Ожидался ключ аренды")
 	}
-	if !equalSlices(returnedTask.Operators, task.Operators) {
-		t.Errorf("Ожидаемые операторы: %v, получено: %v", task.Operators, returnedTask.Operators)
+	task.Attempt = 1
+	task.LeaseDeadline = now.Add(30 * time.Second)
+	task.LeaseToken = returnedTask.LeaseToken
+	if !reflect.DeepEqual(returnedTask, task) {
+		t.Errorf("Ожидаемая задача: %+v, получено: %+v", task, returnedTask)
 	}
 }
 
 func TestReceiveResultHandler(t *testing.T) {
+	app := newTestApp(t)
 	// Добавляем тестовое выражение
-	expressionsMutex.Lock()
-	expressions["test-id"] = &models.Expression{
+	app.expressions.Create(models.Expression{
 		ID:         "test-id",
 		Expression: "1 + 2",
 		Status:     "processing",
-	}
-	expressionsMutex.Unlock()
+	})
+	task := models.Task{ID: "test-task", ExpressionID: "test-id", Arg1: 1, Arg2: 2, Operation: "+"}
+	app.registerTasks("test-id", map[string]*taskNode{"test-task": {task: task}})
+	app.orchestrator.Submit(task)
+	leased := drainTasks(app)[0]
 
-	// Создаем тестовый запрос с результатом
+	// Создаем тестовый запрос с результатом корневой задачи из аренды агента
 	result := models.Result{
-		ID:     "test-id",
-		Result: 3.0,
+		ID:         "test-task",
+		Result:     3.0,
+		LeaseToken: leased.LeaseToken,
 	}
 	reqBody, _ := json.Marshal(result)
 	req := httptest.NewRequest("POST", "/internal/result", bytes.NewReader(reqBody))
@@ -174,7 +324,7 @@ func TestReceiveResultHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Вызываем обработчик
-	ReceiveResultHandler(rr, req)
+	app.ReceiveResultHandler(rr, req)
 
 	// Проверяем статус код
 	if rr.Code != http.StatusOK {
@@ -182,9 +332,7 @@ func TestReceiveResultHandler(t *testing.T) {
 	}
 
 	// Проверяем, что статус выражения обновлен
-	expressionsMutex.Lock()
-	defer expressionsMutex.Unlock()
-	if expr, ok := expressions["test-id"]; ok {
+	if expr, err := app.expressions.Get("test-id"); err == nil {
 		if expr.Status != "completed" {
 			t.Errorf("Ожидаемый статус выражения: completed, получено: %s", expr.Status)
 		}
@@ -196,48 +344,736 @@ func TestReceiveResultHandler(t *testing.T) {
 	}
 }
 
-func TestParseExpression(t *testing.T) {
+func TestRecoverExpressions(t *testing.T) {
+	// Хранилище, оставшееся после перезапуска: одно выражение в работе, одно уже вычислено
+	store := storage.NewMemoryStore()
+	store.Create(models.Expression{ID: "in-flight", Expression: "(1 + 2) * (3 + 4)", Status: "processing"})
+	store.Create(models.Expression{ID: "done", Expression: "5 * 5", Status: "completed", Result: 25})
+
+	app, err := New(WithConfig(&Config{}), WithStore(store))
+	if err != nil {
+		t.Fatalf("Ошибка при создании сервера: %v", err)
+	}
+
+	// Задачи отправляются в очередь в фоне
+	var queued []models.Task
+	deadline := time.Now().Add(time.Second)
+	for len(queued) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Ожидалось 2 задачи в очереди, получено: %d", len(queued))
+		}
+		queued = append(queued, drainTasks(app)...)
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, task := range queued {
+		if task.ExpressionID != "in-flight" {
+			t.Errorf("Ожидалась задача выражения in-flight, получено: %s", task.ExpressionID)
+		}
+		postResult(t, app, task, task.Arg1+task.Arg2)
+	}
+
+	product := drainTasks(app)
+	if len(product) != 1 {
+		t.Fatalf("Ожидалась 1 задача в очереди, получено: %d", len(product))
+	}
+	postResult(t, app, product[0], product[0].Arg1*product[0].Arg2)
+
+	if expr, _ := app.expressions.Get("in-flight"); expr.Status != "completed" || expr.Result != 21 {
+		t.Errorf("Ожидалось завершенное выражение с результатом 21, получено: %+v", expr)
+	}
+}
+
+func TestPlanTasks(t *testing.T) {
+	app := newTestApp(t)
 	tests := []struct {
-		input     string
-		numbers   []float64
-		operators []string
-		err       bool
+		input    string
+		expected float64
+		total    int // всего задач
+		ready    int // задач, готовых сразу
 	}{
-		{"1 + 2 - 3", []float64{1, 2, 3}, []string{"+", "-"}, false},
-		{"1 * 2 / 3", []float64{1, 2, 3}, []string{"*", "/"}, false},
-		{"1 +", nil, nil, true},     // Неверный формат
-		{"1 + 2 *", nil, nil, true}, // Неверный формат
+		{"7", 7, 0, 0},
+		{"1 + 2 - 3", 0, 2, 1},
+		{"2*3", 6, 1, 1},
+		{"(2+3)*(4+5)", 45, 3, 2},
+		{"1 * 2 + 3 * 4 + 5 * 6", 44, 5, 3},
+		{"-3 + 5", 2, 1, 1},
+		{"2 * -4", -8, 1, 1},
+		{"-7", -7, 0, 0},
+		{"-(1+2)", -3, 2, 1},
+		{"+(1+2) * -(3)", -9, 2, 1},
+		{"2^3^2", 512, 2, 1},
+		{"7 // 2 + 7 % 4", 6, 3, 2},
+		{"-2^2", -4, 2, 1},
+		{"sqrt(16)", 4, 1, 1},
+		{"max(1, 2 * 3, 4) + abs(-2)", 8, 4, 2},
+		{"min(3 + 1, sqrt(4), 7)", 2, 3, 2},
+		{"price", 10, 0, 0},
+		{"price * qty * (1 - 0.5)", 15, 3, 2},
 	}
 
 	for _, tt := range tests {
-		numbers, operators, err := parseExpression(tt.input)
-		if tt.err {
-			if err == nil {
-				t.Errorf("Ожидалась ошибка для ввода: %s", tt.input)
+		parsed, err := calculation.Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Неожиданная ошибка разбора для ввода: %q: %v", tt.input, err)
+		}
+
+		graph, value, ready := app.planTasks("expr", parsed.Root, map[string]json.Number{"price": "10", "qty": "3"}, models.PrecisionFloat)
+		if len(graph) != tt.total || len(ready) != tt.ready {
+			t.Errorf("Ожидалось задач: %d (готовых %d), получено: %d (готовых %d) для ввода: %q", tt.total, tt.ready, len(graph), len(ready), tt.input)
+		}
+		if tt.total == 0 && value.value != tt.expected {
+			t.Errorf("Ожидаемое значение: %v, получено: %v для ввода: %q", tt.expected, value.value, tt.input)
+		}
+		if tt.total > 0 {
+			if result := runGraph(t, graph, ready); result != tt.expected {
+				t.Errorf("Ожидаемый результат: %v, получено: %v для ввода: %q", tt.expected, result, tt.input)
 			}
+		}
+	}
+}
+
+func TestAddExpressionHandlerParseError(t *testing.T) {
+	app := newTestApp(t)
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "(1 + 2) * * 3"}`))
+	rr := httptest.NewRecorder()
+
+	app.AddExpressionHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusBadRequest, rr.Code)
+	}
+
+	var response struct {
+		Error     string `json:"error"`
+		ErrorCode string `json:"error_code"`
+		Position  *int   `json:"position"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if response.Error == "" {
+		t.Error("Ожидалось описание ошибки в ответе")
+	}
+	if response.ErrorCode != calculation.CodeInvalidExpression {
+		t.Errorf("Ожидаемый код ошибки: %s, получено: %s", calculation.CodeInvalidExpression, response.ErrorCode)
+	}
+	if response.Position == nil || *response.Position != 10 {
+		t.Errorf("Ожидаемая позиция ошибки: 10, получено: %v", response.Position)
+	}
+}
+
+func TestAddExpressionHandlerVariables(t *testing.T) {
+	app := newTestApp(t)
+
+	reqBody := `{"expression": "price * qty", "variables": {"price": 10.5, "qty": 2}}`
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(reqBody))
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+	var response map[string]string
+	json.NewDecoder(rr.Body).Decode(&response)
+
+	// Значения переменных подставлены в задачу
+	queued := drainTasks(app)
+	if len(queued) != 1 || queued[0].Arg1 != 10.5 || queued[0].Arg2 != 2 {
+		t.Fatalf("Ожидалась задача 10.5 * 2, получено: %+v", queued)
+	}
+
+	// Привязки сохранены вместе с выражением
+	expr, _ := app.expressions.Get(response["id"])
+	if vars := expr.Variables; !reflect.DeepEqual(vars, map[string]json.Number{"price": "10.5", "qty": "2"}) {
+		t.Errorf("Ожидаемые переменные: price=10.5, qty=2, получено: %v", expr.Variables)
+	}
+}
+
+func TestAddExpressionHandlerDecimal(t *testing.T) {
+	app := newTestApp(t)
+
+	reqBody := `{"expression": "(0.1 + x) * 3", "variables": {"x": 0.2}, "precision": "decimal"}`
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(reqBody))
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+	var response map[string]string
+	json.NewDecoder(rr.Body).Decode(&response)
+
+	// Аргументы передаются агенту строками без потери точности
+	queued := drainTasks(app)
+	if len(queued) != 1 || queued[0].Precision != models.PrecisionDecimal || !reflect.DeepEqual(queued[0].DecimalArgs, []string{"1/10", "1/5"}) {
+		t.Fatalf("Ожидалась точная задача 1/10 + 1/5, получено: %+v", queued)
+	}
+	postDecimalResult(t, app, queued[0], "3/10")
+
+	queued = drainTasks(app)
+	if len(queued) != 1 || !reflect.DeepEqual(queued[0].DecimalArgs, []string{"3/10", "3"}) {
+		t.Fatalf("Ожидалась точная задача 3/10 * 3, получено: %+v", queued)
+	}
+	postDecimalResult(t, app, queued[0], "9/10")
+
+	expr, _ := app.expressions.Get(response["id"])
+	if expr.Status != "completed" || expr.Decimal != "0.9" || expr.Result != 0.9 {
+		t.Errorf("Ожидался результат 0.9, получено: %s, %q, %v", expr.Status, expr.Decimal, expr.Result)
+	}
+}
+
+func TestAddExpressionHandlerInvalidPrecision(t *testing.T) {
+	app := newTestApp(t)
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 + 2", "precision": "quad"}`))
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusBadRequest, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Ожидался Content-Type application/json, получено: %q", ct)
+	}
+	var response models.ExpressionError
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if response.ErrorCode != CodeInvalidRequest || !strings.Contains(response.Error, "quad") {
+		t.Errorf("Ожидалась ошибка %s о режиме quad, получено: %+v", CodeInvalidRequest, response)
+	}
+}
+
+func TestAddExpressionHandlerMissingVariables(t *testing.T) {
+	app := newTestApp(t)
+	reqBody := `{"expression": "price * qty * (1 - discount)", "variables": {"price": 10.5}}`
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(reqBody))
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusBadRequest, rr.Code)
+	}
+	var response struct {
+		Error   string   `json:"error"`
+		Missing []string `json:"missing_variables"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if !reflect.DeepEqual(response.Missing, []string{"discount", "qty"}) {
+		t.Errorf("Ожидаемые недостающие переменные: [discount qty], получено: %v", response.Missing)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	// Два сервера в одном процессе не делят ни хранилище, ни очередь
+	first, second := newTestApp(t), newTestApp(t)
+	firstServer, secondServer := httptest.NewServer(first.Handler()), httptest.NewServer(second.Handler())
+	defer firstServer.Close()
+	defer secondServer.Close()
+
+	resp, err := http.Post(firstServer.URL+"/api/v1/calculate", "application/json", strings.NewReader(`{"expression": "2 * 3"}`))
+	if err != nil {
+		t.Fatalf("Ошибка при отправке выражения: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// ID выражения и задачи выдает генератор, заданный при создании сервера
+	resp, err = http.Get(firstServer.URL + "/api/v1/expressions/id-1")
+	if err != nil {
+		t.Fatalf("Ошибка при запросе выражения: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = http.Get(secondServer.URL + "/api/v1/expressions/id-1")
+	if err != nil {
+		t.Fatalf("Ошибка при запросе выражения: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err = http.Get(secondServer.URL + "/internal/task?agent_id=agent1")
+	if err != nil {
+		t.Fatalf("Ошибка при запросе задачи: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	var task models.Task
+	resp, err = http.Get(firstServer.URL + "/internal/task?agent_id=agent1")
+	if err != nil {
+		t.Fatalf("Ошибка при запросе задачи: %v", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&task)
+	resp.Body.Close()
+	if task.ID != "id-2" || task.ExpressionID != "id-1" {
+		t.Errorf("Ожидалась задача id-2 выражения id-1, получено: %+v", task)
+	}
+}
+
+func TestAgentLoad(t *testing.T) {
+	app := newTestApp(t)
+	handler := app.Handler()
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+	agentLoad := func() []orchestrator.AgentInfo {
+		var response struct {
+			Agents []orchestrator.AgentInfo `json:"agents"`
+		}
+		json.NewDecoder(serve("GET", "/api/v1/agents", "").Body).Decode(&response)
+		return response.Agents
+	}
+
+	if rr := serve("POST", "/internal/agents", `{"id": "agent1", "computing_power": 1}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+	serve("POST", "/api/v1/calculate", `{"expression": "2 * 3"}`)
+
+	// Задача, выданная агенту, учитывается в его нагрузке до получения результата
+	var task models.Task
+	json.NewDecoder(serve("GET", "/internal/task?agent_id=agent1", "").Body).Decode(&task)
+	if agents := agentLoad(); len(agents) != 1 || agents[0].Load != 1 {
+		t.Fatalf("Ожидалась нагрузка 1 у agent1, получено: %+v", agents)
+	}
+
+	sendResult(t, app, models.Result{ID: task.ID, Result: 6, LeaseToken: task.LeaseToken})
+	if agents := agentLoad(); len(agents) != 1 || agents[0].Load != 0 {
+		t.Errorf("Ожидалась нагрузка 0 у agent1, получено: %+v", agents)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	app := newTestApp(t,
+		WithConfig(&Config{LeaseTimeout: time.Second, MaxAttempts: 2}),
+		WithClock(func() time.Time { return now }),
+	)
+
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2 * 3"}`)))
+	var response map[string]string
+	json.NewDecoder(rr.Body).Decode(&response)
+
+	// Агент взял задачу и пропал: после истечения аренды задача снова в очереди
+	first := drainTasks(app)
+	if len(first) != 1 || first[0].Attempt != 1 {
+		t.Fatalf("Ожидалась задача с попыткой 1, получено: %+v", first)
+	}
+	now = now.Add(500 * time.Millisecond)
+	app.expireLeases()
+	if queued := drainTasks(app); len(queued) != 0 {
+		t.Fatalf("Аренда еще не истекла, но задача вернулась в очередь: %+v", queued)
+	}
+
+	now = now.Add(time.Second)
+	app.expireLeases()
+	second := drainTasks(app)
+	if len(second) != 1 || second[0].ID != first[0].ID || second[0].Attempt != 2 {
+		t.Fatalf("Ожидалась та же задача с попыткой 2, получено: %+v", second)
+	}
+
+	// Последняя попытка тоже не удалась: выражение завершается ошибкой
+	now = now.Add(2 * time.Second)
+	app.expireLeases()
+	if queued := drainTasks(app); len(queued) != 0 {
+		t.Errorf("Ожидалась пустая очередь, получено: %+v", queued)
+	}
+	expr, _ := app.expressions.Get(response["id"])
+	if expr.Status != "failed" || !strings.Contains(expr.Error, "2 попыток") {
+		t.Errorf("Ожидалось выражение в статусе failed, получено: %+v", expr)
+	}
+}
+
+func TestReceiveErrorResult(t *testing.T) {
+	app := newTestApp(t)
+
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 / 0 + 2 * 3"}`)))
+	var response map[string]string
+	json.NewDecoder(rr.Body).Decode(&response)
+
+	queued := drainTasks(app)
+	if len(queued) != 2 {
+		t.Fatalf("Ожидалось 2 задачи в очереди, получено: %d", len(queued))
+	}
+	for _, task := range queued {
+		if task.Operation == "/" {
+			reqBody, _ := json.Marshal(models.Result{ID: task.ID, ErrorCode: calculation.CodeDivisionByZero, Error: "деление на ноль", LeaseToken: task.LeaseToken})
+			rr := httptest.NewRecorder()
+			app.ReceiveResultHandler(rr, httptest.NewRequest("POST", "/internal/result", bytes.NewReader(reqBody)))
 		} else {
-			if err != nil {
-				t.Errorf("Неожиданная ошибка для ввода: %s: %v", tt.input, err)
-			}
-			if !equalSlices(numbers, tt.numbers) {
-				t.Errorf("Ожидаемые числа: %v, получено: %v для ввода: %s", tt.numbers, numbers, tt.input)
-			}
-			if !equalSlices(operators, tt.operators) {
-				t.Errorf("Ожидаемые операторы: %v, получено: %v для ввода: %s", tt.operators, operators, tt.input)
-			}
+			postResult(t, app, task, 6)
+		}
+	}
+
+	// Сложение уже не нужно: выражение завершилось ошибкой
+	if queued := drainTasks(app); len(queued) != 0 {
+		t.Errorf("Ожидалась пустая очередь, получено: %+v", queued)
+	}
+	expr, _ := app.expressions.Get(response["id"])
+	if expr.Status != models.StatusFailed || expr.ErrorCode != calculation.CodeDivisionByZero || expr.Error != "деление на ноль" {
+		t.Errorf("Ожидалось выражение в статусе failed с кодом DIVISION_BY_ZERO, получено: %+v", expr)
+	}
+}
+
+func TestDuplicateResult(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	app := newTestApp(t,
+		WithConfig(&Config{LeaseTimeout: time.Second, MaxAttempts: 1}),
+		WithClock(func() time.Time { return now }),
+	)
+	addExpression(t, app, `{"expression": "2 + 3 * 4"}`)
+
+	// Повторный результат не ставит зависящую задачу в очередь второй раз
+	mul := drainTasks(app)
+	postResult(t, app, mul[0], 12)
+	postResult(t, app, mul[0], 12)
+	add := drainTasks(app)
+	if len(add) != 1 || add[0].Arg2 != 12 {
+		t.Fatalf("Ожидалась одна задача 2 + 12, получено: %+v", add)
+	}
+
+	// Повторный результат корневой задачи не перезаписывает результат выражения
+	postResult(t, app, add[0], 14)
+	postResult(t, app, add[0], 99)
+	expr, _ := app.expressions.Get("id-1")
+	if expr.Status != models.StatusCompleted || expr.Result != 14 {
+		t.Errorf("Ожидалось выражение с результатом 14, получено: %+v", expr)
+	}
+
+	// Аренда копии задачи, выданной повторно, истекла уже после получения результата: выражение не портится
+	app.orchestrator.TrySubmit(add[0])
+	drainTasks(app)
+	now = now.Add(2 * time.Second)
+	app.expireLeases()
+	if expr, _ := app.expressions.Get("id-1"); expr.Status != models.StatusCompleted {
+		t.Errorf("Ожидалось выражение в статусе completed, получено: %+v", expr)
+	}
+}
+
+func TestReceiveResultValidation(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	app := newTestApp(t,
+		WithConfig(&Config{LeaseTimeout: time.Second, MaxAttempts: 3}),
+		WithClock(func() time.Time { return now }),
+	)
+	app.orchestrator.Register(models.Agent{ID: "agent1", ComputingPower: 1})
+	app.orchestrator.Register(models.Agent{ID: "agent2", ComputingPower: 1})
+	addExpression(t, app, `{"expression": "2 * 3"}`)
+
+	// agent1 не успел за время аренды, задачу получил agent2
+	first, _ := app.orchestrator.Next("agent1")
+	now = now.Add(2 * time.Second)
+	app.orchestrator.Heartbeat("agent1", nil)
+	app.orchestrator.Heartbeat("agent2", nil)
+	app.expireLeases()
+	second, _ := app.orchestrator.Next("agent2")
+	if second.ID != first.ID || second.Attempt != 2 {
+		t.Fatalf("Ожидалась та же задача с попыткой 2, получено: %+v", second)
+	}
+	addExpression(t, app, `{"expression": "1 + 1"}`)
+	anonymous, _ := app.orchestrator.Next("")
+
+	tests := []struct {
+		name     string
+		result   models.Result
+		expected int
+	}{
+		{"неизвестная задача", models.Result{ID: "unknown", LeaseToken: second.LeaseToken, Result: 6}, http.StatusNotFound},
+		{"истекшая аренда", models.Result{ID: first.ID, LeaseToken: first.LeaseToken, Result: 7}, http.StatusConflict},
+		{"чужой ключ", models.Result{ID: first.ID, LeaseToken: "forged", Result: 7}, http.StatusConflict},
+		{"без ключа", models.Result{ID: first.ID, Result: 999}, http.StatusConflict},
+		{"текущая аренда", models.Result{ID: first.ID, LeaseToken: second.LeaseToken, Result: 6}, http.StatusOK},
+		{"повтор", models.Result{ID: first.ID, ExpressionID: "id-1", LeaseToken: second.LeaseToken, Result: 8}, http.StatusOK},
+		{"опоздавший агент после выполнения", models.Result{ID: first.ID, ExpressionID: "id-1", LeaseToken: first.LeaseToken, Result: 9}, http.StatusOK},
+		{"повтор без выражения", models.Result{ID: first.ID, Result: 8}, http.StatusOK},
+		{"задача другого выражения", models.Result{ID: first.ID, ExpressionID: anonymous.ExpressionID, Result: 8}, http.StatusNotFound},
+		{"задача неизвестного выражения", models.Result{ID: "unknown", ExpressionID: "unknown"}, http.StatusNotFound},
+		// Агент, не передающий ни свой ID, ни ключ аренды
+		{"аренда без агента", models.Result{ID: anonymous.ID, Result: 2}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		reqBody, _ := json.Marshal(tt.result)
+		rr := httptest.NewRecorder()
+		app.ReceiveResultHandler(rr, httptest.NewRequest("POST", "/internal/result", bytes.NewReader(reqBody)))
+		if rr.Code != tt.expected {
+			t.Errorf("%s: ожидаемый статус код: %d, получено: %d", tt.name, tt.expected, rr.Code)
+		}
+	}
+
+	expr, _ := app.expressions.Get("id-1")
+	if expr.Status != models.StatusCompleted || expr.Result != 6 {
+		t.Errorf("Ожидалось выражение с результатом 6, получено: %+v", expr)
+	}
+	if expr, _ := app.expressions.Get(anonymous.ExpressionID); expr.Status != models.StatusCompleted || expr.Result != 2 {
+		t.Errorf("Ожидалось выражение с результатом 2, получено: %+v", expr)
+	}
+	if leased := app.orchestrator.Leased(); leased != 0 {
+		t.Errorf("Ожидалось 0 выданных задач, получено: %d", leased)
+	}
+}
+
+func TestGetTaskHandlerWait(t *testing.T) {
+	app := newTestApp(t)
+	handler := app.Handler()
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{"/internal/task?agent_id=agent1", http.StatusNotFound},
+		{"/internal/task?agent_id=agent1&wait=20ms", http.StatusNoContent},
+		{"/internal/task?agent_id=agent1&wait=soon", http.StatusBadRequest},
+		{"/internal/task?agent_id=agent1&wait=-1s", http.StatusBadRequest},
+		{"/internal/task", http.StatusNotFound},
+		{"/internal/task?wait=20ms", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if rr := serve(tt.path); rr.Code != tt.expected {
+			t.Errorf("Ожидаемый статус код: %d, получено: %d для %s", tt.expected, rr.Code, tt.path)
+		}
+	}
+
+	// Запрос ждет, пока не появится задача
+	got := make(chan *httptest.ResponseRecorder, 1)
+	go func() { got <- serve("/internal/task?agent_id=agent1&wait=5s") }()
+	time.Sleep(20 * time.Millisecond)
+	app.AddExpressionHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2 * 3"}`)))
+	select {
+	case rr := <-got:
+		var task models.Task
+		json.NewDecoder(rr.Body).Decode(&task)
+		if rr.Code != http.StatusOK || task.Operation != "*" {
+			t.Errorf("Ожидалась задача 2 * 3, получено: %d %+v", rr.Code, task)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Задача не выдана ожидающему агенту")
+	}
+
+	// Остановка сервера прерывает ожидание
+	go func() { got <- serve("/internal/task?agent_id=agent1&wait=5s") }()
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go app.Drain(ctx)
+	select {
+	case rr := <-got:
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusNoContent, rr.Code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Ожидание задачи не прервано остановкой сервера")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	app := newTestApp(t)
+	handler := app.Handler()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	serve("POST", "/api/v1/calculate", `{"expression": "2 * 3"}`)
+	serve("POST", "/api/v1/calculate", `{"expression": "4 * 5"}`)
+	var task models.Task
+	json.NewDecoder(serve("GET", "/internal/task?agent_id="+testAgent, "").Body).Decode(&task)
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drained <- app.Drain(ctx)
+	}()
+	time.Sleep(2 * drainCheckInterval)
+
+	// Во время остановки новые выражения не принимаются, а задачи не выдаются
+	if rr := serve("POST", "/api/v1/calculate", `{"expression": "1 + 1"}`); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if rr := serve("GET", "/internal/task?agent_id="+testAgent, ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("Остановка завершилась до получения результата выданной задачи: %v", err)
+	default:
+	}
+
+	// Результат выданной задачи принимается, и остановка завершается
+	postResult(t, app, task, 6)
+	if err := <-drained; err != nil {
+		t.Errorf("Неожиданная ошибка остановки: %v", err)
+	}
+	if expr, _ := app.expressions.Get(task.ExpressionID); expr.Status != models.StatusCompleted {
+		t.Errorf("Ожидалось завершенное выражение, получено: %+v", expr)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	app := newTestApp(t)
+	app.AddExpressionHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "2 * 3"}`)))
+	drainTasks(app)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := app.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ожидалась ошибка: %v, получено: %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestConfigFromEnvGRPC(t *testing.T) {
+	tests := []struct {
+		env      string
+		expected string
+	}{
+		{"", "9090"},
+		{"9191", "9191"},
+		{"off", ""},
+	}
+	for _, tt := range tests {
+		t.Setenv("GRPC_PORT", tt.env)
+		if config := ConfigFromEnv(); config.GRPCAddr != tt.expected {
+			t.Errorf("GRPC_PORT=%q: ожидаемый порт gRPC: %q, получено: %q", tt.env, tt.expected, config.GRPCAddr)
+		}
+	}
+}
+
+func TestQueueSizeOption(t *testing.T) {
+	app := newTestApp(t, WithQueueSize(1))
+
+	// Два независимых умножения не помещаются в очередь из одной задачи
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "1 * 2 + 3 * 4"}`))
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+// Вспомогательная функция, создающая сервер с хранилищем в памяти и последовательными ID
+func newTestApp(t *testing.T, opts ...Option) *Application {
+	t.Helper()
+	var counter int
+	newID := func() string {
+		counter++
+		return fmt.Sprintf("id-%d", counter)
+	}
+	opts = append([]Option{WithConfig(&Config{}), WithStore(storage.NewMemoryStore()), WithIDGenerator(newID)}, opts...)
+	app, err := New(opts...)
+	if err != nil {
+		t.Fatalf("Ошибка при создании сервера: %v", err)
+	}
+	t.Cleanup(func() { app.Close() })
+	return app
+}
+
+// Вспомогательная функция, вычисляющая задачу так же, как это делают агенты
+func computeTask(t *testing.T, task models.Task) float64 {
+	t.Helper()
+	var value float64
+	var err error
+	if task.Type == models.TaskFunction {
+		value, err = calculation.Call(task.Function, task.Args)
+	} else {
+		value, err = calculation.Apply(task.Operation, task.Arg1, task.Arg2)
+	}
+	if err != nil {
+		t.Fatalf("Ошибка вычисления задачи %+v: %v", task, err)
+	}
+	return value
+}
+
+// Вспомогательная функция, получающая из очереди count задач (в том числе поставленных в фоне),
+// вычисляющая их и отправляющая результаты от имени testAgent
+func computeTasks(t *testing.T, app *Application, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		task, ok := app.orchestrator.NextWait(ctx, testAgent)
+		cancel()
+		if !ok {
+			t.Fatalf("Ожидалась задача %d из %d", i+1, count)
 		}
+		postResult(t, app, task, computeTask(t, task))
 	}
 }
 
-// Вспомогательная функция для сравнения слайсов
-func equalSlices[T comparable](a, b []T) bool {
-	if len(a) != len(b) {
-		return false
+// Вспомогательная функция, выполняющая граф задач без сервера
+func runGraph(t *testing.T, graph map[string]*taskNode, ready []models.Task) float64 {
+	t.Helper()
+	for len(ready) > 0 {
+		task := ready[0]
+		ready = ready[1:]
+		value := computeTask(t, task)
+		tn := graph[task.ID]
+		if tn.parent == "" {
+			return value
+		}
+		parent := graph[tn.parent]
+		parent.setArg(tn.slot, models.Result{ID: task.ID, Result: value})
+		if parent.pending--; parent.pending == 0 {
+			ready = append(ready, parent.task)
+		}
 	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
+	t.Fatal("Корневая задача не была выполнена")
+	return 0
+}
+
+// testAgent — агент, которому drainTasks выдает задачи
+const testAgent = "test-agent"
+
+// Вспомогательная функция для извлечения всех задач из очереди
+func drainTasks(app *Application) []models.Task {
+	var queued []models.Task
+	for {
+		task, ok := app.orchestrator.Next(testAgent)
+		if !ok {
+			return queued
 		}
+		queued = append(queued, task)
+	}
+}
+
+// Вспомогательная функция для отправки результата задачи, выданной testAgent
+func postResult(t *testing.T, app *Application, task models.Task, value float64) {
+	t.Helper()
+	sendResult(t, app, models.Result{ID: task.ID, ExpressionID: task.ExpressionID, Result: value, LeaseToken: task.LeaseToken})
+}
+
+// Вспомогательная функция для отправки точного результата задачи, выданной testAgent
+func postDecimalResult(t *testing.T, app *Application, task models.Task, decimal string) {
+	t.Helper()
+	sendResult(t, app, models.Result{ID: task.ID, ExpressionID: task.ExpressionID, Decimal: decimal, LeaseToken: task.LeaseToken})
+}
+
+// Вспомогательная функция, отправляющая результат через POST /internal/result и ожидающая 200
+func sendResult(t *testing.T, app *Application, result models.Result) {
+	t.Helper()
+	reqBody, _ := json.Marshal(result)
+	req := httptest.NewRequest("POST", "/internal/result", bytes.NewReader(reqBody))
+	rr := httptest.NewRecorder()
+	app.ReceiveResultHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusOK, rr.Code)
 	}
-	return true
 }
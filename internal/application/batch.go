@@ -0,0 +1,121 @@
+package application
+
+import (
+	"Second_sprint_final_task/internal/storage"
+	"Second_sprint_final_task/pkg/calculation"
+	"Second_sprint_final_task/pkg/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// maxBatchSize — наибольшее число выражений в одном пакете
+const maxBatchSize = 10000
+
+// AddBatchHandler принимает пакет выражений. Каждое выражение проверяется отдельно: выражения
+// с ошибками отклоняются, остальные принимаются. Ответ содержит ID пакета и для каждого
+// выражения в порядке запроса его ID или описание ошибки. Задачи принятых выражений ставятся
+// в очередь в фоне, поэтому большой пакет не упирается в размер очереди
+func (a *Application) AddBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if a.drainCtx.Err() != nil {
+		http.Error(w, "Сервер останавливается", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Expressions []expressionRequest `json:"expressions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	if len(req.Expressions) == 0 {
+		http.Error(w, "Пакет не содержит выражений", http.StatusBadRequest)
+		return
+	}
+	if len(req.Expressions) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("В пакете больше %d выражений", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	batch := models.Batch{ID: a.newID(), Items: make([]models.BatchItem, len(req.Expressions))}
+	var queue []models.Task
+	for i, item := range req.Expressions {
+		batch.Items[i].ClientID = item.ClientID
+		expr, graph, ready, err := a.prepareExpression(item)
+		if err != nil {
+			batch.Items[i].ExpressionError = expressionError(err)
+			continue
+		}
+		expr.BatchID = batch.ID
+		if err := a.saveExpression(expr, graph); err != nil {
+			batch.Items[i].ExpressionError = &models.ExpressionError{Error: "ошибка при сохранении выражения", ErrorCode: calculation.CodeUnknown}
+			continue
+		}
+		batch.Items[i].ExpressionID = expr.ID
+		queue = append(queue, ready...)
+	}
+
+	if err := a.expressions.CreateBatch(batch); err != nil {
+		// Без пакета клиент не узнает ID принятых выражений: они завершаются ошибкой и не вычисляются
+		log.Printf("Ошибка при сохранении пакета %s: %v", batch.ID, err)
+		a.tasksMutex.Lock()
+		for _, item := range batch.Items {
+			if item.ExpressionID != "" {
+				a.failExpression(item.ExpressionID, calculation.CodeUnknown, "ошибка при сохранении пакета")
+			}
+		}
+		a.tasksMutex.Unlock()
+		http.Error(w, "Ошибка при сохранении пакета", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(batch)
+	a.orchestrator.Enqueue(queue)
+}
+
+// GetBatchHandler возвращает состояние пакета: число выражений по статусам и каждое выражение
+// с текущим результатом
+func (a *Application) GetBatchHandler(w http.ResponseWriter, r *http.Request) {
+	batch, err := a.expressions.GetBatch(mux.Vars(r)["id"])
+	if errors.Is(err, storage.ErrBatchNotFound) {
+		http.Error(w, "Пакет не найден", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при чтении пакета", http.StatusInternalServerError)
+		return
+	}
+
+	progress := models.BatchProgress{
+		ID:     batch.ID,
+		Total:  len(batch.Items),
+		Counts: make(map[string]int),
+		Done:   true,
+		Items:  make([]models.BatchResult, len(batch.Items)),
+	}
+	for i, item := range batch.Items {
+		progress.Items[i].BatchItem = item
+		if item.ExpressionError != nil {
+			progress.Counts["rejected"]++
+			continue
+		}
+		expr, err := a.expressions.Get(item.ExpressionID)
+		if err != nil {
+			http.Error(w, "Ошибка при чтении выражения "+item.ExpressionID, http.StatusInternalServerError)
+			return
+		}
+		progress.Items[i].Expression = &expr
+		progress.Counts[expr.Status]++
+		if !isFinal(expr.Status) {
+			progress.Done = false
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(progress)
+}
@@ -0,0 +1,200 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Second_sprint_final_task/internal/storage"
+	"Second_sprint_final_task/pkg/calculation"
+	"Second_sprint_final_task/pkg/models"
+)
+
+// Вспомогательная функция, запрашивающая состояние пакета
+func getBatch(t *testing.T, app *Application, id string) (int, models.BatchProgress) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/batches/"+id, nil))
+	var progress models.BatchProgress
+	json.NewDecoder(rr.Body).Decode(&progress)
+	return rr.Code, progress
+}
+
+func TestAddBatchHandler(t *testing.T) {
+	app := newTestApp(t)
+	body := `{"expressions": [
+		{"client_id": "a", "expression": "2 + 3"},
+		{"client_id": "b", "expression": "2 +"},
+		{"client_id": "c", "expression": "x * 2"},
+		{"client_id": "d", "expression": "x * 2", "variables": {"x": 4}},
+		{"client_id": "e", "expression": "7"},
+		{"client_id": "f", "expression": "1 + 1", "precision": "binary"}
+	]}`
+	rr := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/calculate/batch", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+	var batch models.Batch
+	if err := json.NewDecoder(rr.Body).Decode(&batch); err != nil {
+		t.Fatalf("Ошибка при чтении ответа: %v", err)
+	}
+
+	tests := []struct {
+		clientID     string
+		expressionID string
+		errorCode    string
+	}{
+		{"a", "id-2", ""},
+		{"b", "", "INVALID_EXPRESSION"},
+		{"c", "", "UNBOUND_VARIABLE"},
+		{"d", "id-4", ""},
+		{"e", "id-6", ""},
+		{"f", "", CodeInvalidRequest},
+	}
+	if batch.ID != "id-1" || len(batch.Items) != len(tests) {
+		t.Fatalf("Ожидался пакет id-1 из %d выражений, получено: %+v", len(tests), batch)
+	}
+	for i, tt := range tests {
+		item := batch.Items[i]
+		code := ""
+		if item.ExpressionError != nil {
+			code = item.ErrorCode
+		}
+		if item.ClientID != tt.clientID || item.ExpressionID != tt.expressionID || code != tt.errorCode {
+			t.Errorf("Элемент %d: ожидалось %+v, получено: %+v (%+v)", i, tt, item, item.ExpressionError)
+		}
+	}
+	if item := batch.Items[1]; item.Position == nil {
+		t.Errorf("Ожидалась позиция синтаксической ошибки, получено: %+v", item.ExpressionError)
+	}
+	if item := batch.Items[2]; len(item.MissingVariables) != 1 || item.MissingVariables[0] != "x" {
+		t.Errorf("Ожидалась недостающая переменная x, получено: %+v", item.ExpressionError)
+	}
+	if expr, _ := app.expressions.Get("id-2"); expr.BatchID != "id-1" {
+		t.Errorf("Ожидалось выражение пакета id-1, получено: %+v", expr)
+	}
+
+	code, progress := getBatch(t, app, "id-1")
+	if code != http.StatusOK || progress.Done || progress.Total != 6 ||
+		progress.Counts[models.StatusPending] != 2 || progress.Counts[models.StatusCompleted] != 1 || progress.Counts["rejected"] != 3 {
+		t.Errorf("Неожиданное состояние пакета: %d, %+v", code, progress)
+	}
+
+	computeTasks(t, app, 2)
+	_, progress = getBatch(t, app, "id-1")
+	if !progress.Done || progress.Counts[models.StatusCompleted] != 3 {
+		t.Errorf("Ожидался завершенный пакет, получено: %+v", progress)
+	}
+	for i, want := range map[int]float64{0: 5, 3: 8, 4: 7} {
+		if expr := progress.Items[i].Expression; expr == nil || expr.Result != want {
+			t.Errorf("Элемент %d: ожидался результат %v, получено: %+v", i, want, expr)
+		}
+	}
+}
+
+func TestAddBatchHandlerLargerThanQueue(t *testing.T) {
+	app := newTestApp(t, WithQueueSize(1))
+	items := make([]string, 5)
+	for i := range items {
+		items[i] = `{"expression": "1 + 1"}`
+	}
+	rr := httptest.NewRecorder()
+	app.AddBatchHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate/batch", strings.NewReader(`{"expressions": [`+strings.Join(items, ",")+`]}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+
+	// Задачи, не поместившиеся в очередь, попадают в нее по мере того, как агенты ее разбирают
+	computeTasks(t, app, 5)
+	if _, progress := getBatch(t, app, "id-1"); !progress.Done || progress.Counts[models.StatusCompleted] != 5 {
+		t.Errorf("Ожидался завершенный пакет, получено: %+v", progress)
+	}
+}
+
+func TestResultWithFullQueue(t *testing.T) {
+	app := newTestApp(t, WithQueueSize(1))
+	rr := httptest.NewRecorder()
+	app.AddBatchHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate/batch", strings.NewReader(`{"expressions": [{"expression": "(1 + 2) * 3"}, {"expression": "4 + 5"}]}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+
+	// Пока агент вычислял 1 + 2, очередь заняла задача второго выражения. Результат,
+	// после которого готова задача умножения, принимается, не дожидаясь места в очереди
+	task, _ := app.orchestrator.Next(testAgent)
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		postResult(t, app, task, computeTask(t, task))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Прием результата заблокировался на полной очереди")
+	}
+
+	computeTasks(t, app, 2)
+	if _, progress := getBatch(t, app, "id-1"); !progress.Done || progress.Items[0].Expression.Result != 9 || progress.Items[1].Expression.Result != 9 {
+		t.Errorf("Ожидался завершенный пакет с результатами 9 и 9, получено: %+v", progress)
+	}
+}
+
+func TestBatchHandlerErrors(t *testing.T) {
+	app := newTestApp(t)
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+	}{
+		{"Пустой пакет", "POST", "/api/v1/calculate/batch", `{"expressions": []}`, http.StatusBadRequest},
+		{"Неверный формат", "POST", "/api/v1/calculate/batch", `{"expressions": "2 + 2"}`, http.StatusBadRequest},
+		{"Неизвестный пакет", "GET", "/api/v1/batches/unknown", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.Handler().ServeHTTP(rr, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Ожидаемый статус код: %d, получено: %d", tt.wantStatus, rr.Code)
+			}
+		})
+	}
+}
+
+// Хранилище, которое не может сохранить пакет
+type failingBatchStore struct {
+	storage.ExpressionStore
+}
+
+func (failingBatchStore) CreateBatch(models.Batch) error {
+	return errors.New("диск переполнен")
+}
+
+func TestAddBatchHandlerStoreError(t *testing.T) {
+	app := newTestApp(t, WithStore(failingBatchStore{storage.NewMemoryStore()}))
+	rr := httptest.NewRecorder()
+	app.AddBatchHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate/batch", strings.NewReader(`{"expressions": [{"expression": "1 + 1"}, {"expression": "7"}]}`)))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusInternalServerError, rr.Code)
+	}
+
+	// Принятое выражение завершается ошибкой, его задачи не попадают к агентам
+	if expr, _ := app.expressions.Get("id-2"); expr.Status != models.StatusFailed || expr.ErrorCode != calculation.CodeUnknown {
+		t.Errorf("Ожидалось выражение в статусе failed, получено: %+v", expr)
+	}
+	if expr, _ := app.expressions.Get("id-4"); expr.Status != models.StatusCompleted {
+		t.Errorf("Ожидалось уже вычисленное выражение, получено: %+v", expr)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if task, ok := app.orchestrator.Next(testAgent); ok {
+		t.Errorf("Ожидалась пустая очередь, получено: %+v", task)
+	}
+}
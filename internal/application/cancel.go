@@ -0,0 +1,86 @@
+package application
+
+import (
+	"Second_sprint_final_task/internal/storage"
+	"Second_sprint_final_task/pkg/models"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// ErrExpressionFinished — выражение уже вычислено или завершилось ошибкой, отменять нечего
+var ErrExpressionFinished = errors.New("выражение уже завершено")
+
+// CancelExpressionHandler отменяет вычисление выражения и возвращает его в статусе cancelled.
+// Повторная отмена ничего не меняет; завершенное выражение отменить нельзя (409)
+func (a *Application) CancelExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := a.cancelExpression(id)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, "Выражение не найдено", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrExpressionFinished):
+		http.Error(w, "Выражение уже завершено", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Ошибка при отмене выражения", http.StatusInternalServerError)
+		return
+	}
+
+	expr, err := a.expressions.Get(id)
+	if err != nil {
+		http.Error(w, "Ошибка при чтении выражения", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(expr)
+}
+
+// cancelExpression переводит выражение в статус cancelled и забывает его задачи: результаты,
+// присланные после отмены, подтверждаются и отбрасываются. Статус меняется под tasksMutex вместе
+// с забыванием задач, поэтому результат или ошибка задачи не могут вклиниться между ними.
+// Задачи выражения убираются из очереди, а агенты, которые их вычисляют, узнают об отмене
+// из ответа на heartbeat
+func (a *Application) cancelExpression(id string) error {
+	a.tasksMutex.Lock()
+	expr, err := a.expressions.Get(id)
+	if err != nil {
+		a.tasksMutex.Unlock()
+		return err
+	}
+	if expr.Status == models.StatusCancelled {
+		a.tasksMutex.Unlock()
+		return nil
+	}
+	err = a.expressions.SetCancelled(id, a.now())
+	if err == nil {
+		a.forgetExpression(id)
+	}
+	a.tasksMutex.Unlock()
+	if errors.Is(err, storage.ErrFinished) {
+		return ErrExpressionFinished
+	}
+	if err != nil {
+		log.Printf("Ошибка при отмене выражения %s: %v", id, err)
+		return err
+	}
+
+	// Задачи выражения в очереди больше не ждут (staleTask), агенты их не получат
+	abandoned := a.orchestrator.Cancel(id)
+	log.Printf("Выражение %s отменено, отозвано задач у агентов: %d", id, abandoned)
+	a.publishExpression(id)
+	a.notify(id)
+	return nil
+}
+
+// staleTask сообщает, что задача из очереди больше не нужна: сервер не ждет ее результат,
+// потому что он уже получен, выражение отменено или завершилось ошибкой
+func (a *Application) staleTask(task models.Task) bool {
+	a.tasksMutex.Lock()
+	defer a.tasksMutex.Unlock()
+	return !a.awaiting(task.ID)
+}
@@ -0,0 +1,137 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+// Вспомогательная функция, отменяющая выражение через DELETE /api/v1/expressions/{id}
+func cancelExpression(t *testing.T, app *Application, id string) (int, models.Expression) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rr, httptest.NewRequest("DELETE", "/api/v1/expressions/"+id, nil))
+	var expr models.Expression
+	json.NewDecoder(rr.Body).Decode(&expr)
+	return rr.Code, expr
+}
+
+func TestCancelExpression(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	app := newTestApp(t, WithClock(func() time.Time { return now }))
+	app.orchestrator.Register(models.Agent{ID: "agent1", ComputingPower: 1})
+	addExpression(t, app, `{"expression": "(1 + 2) * (3 + 4)"}`)
+
+	// Одна задача у агента, вторая ждет в очереди
+	leased, ok := app.orchestrator.Next("agent1")
+	if !ok {
+		t.Fatal("Ожидалась задача в очереди")
+	}
+
+	code, expr := cancelExpression(t, app, "id-1")
+	if code != http.StatusOK || expr.Status != models.StatusCancelled || !expr.CompletedAt.Equal(now) {
+		t.Fatalf("Ожидалось отмененное выражение, получено: %d, %+v", code, expr)
+	}
+	if task, ok := app.orchestrator.Next("agent1"); ok {
+		t.Errorf("Ожидалось, что задачи выражения убраны из очереди, получено: %+v", task)
+	}
+
+	// Агент узнает об отмене из ответа на heartbeat
+	rr := httptest.NewRecorder()
+	app.Handler().ServeHTTP(rr, httptest.NewRequest("POST", "/internal/agents/agent1/heartbeat", nil))
+	var heartbeat struct {
+		CancelledTasks []string `json:"cancelled_tasks"`
+	}
+	json.NewDecoder(rr.Body).Decode(&heartbeat)
+	if rr.Code != http.StatusOK || len(heartbeat.CancelledTasks) != 1 || heartbeat.CancelledTasks[0] != leased.ID {
+		t.Errorf("Ожидалась отмененная задача %s в ответе на heartbeat, получено: %d, %+v", leased.ID, rr.Code, heartbeat)
+	}
+
+	// Результат, присланный после отмены, подтверждается и отбрасывается
+	body, _ := json.Marshal(models.Result{ID: leased.ID, ExpressionID: "id-1", Result: 3, LeaseToken: leased.LeaseToken})
+	rr = httptest.NewRecorder()
+	app.ReceiveResultHandler(rr, httptest.NewRequest("POST", "/internal/result", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Ожидаемый статус код: %d, получено: %d", http.StatusOK, rr.Code)
+	}
+
+	// Задача, которую фоновая горутина вернула в очередь после отмены, агентам не выдается
+	app.orchestrator.Submit(leased)
+	if task, ok := app.orchestrator.Next("agent1"); ok {
+		t.Errorf("Ожидалось, что задача отмененного выражения не будет выдана, получено: %+v", task)
+	}
+
+	// Повторная отмена ничего не меняет
+	if code, expr := cancelExpression(t, app, "id-1"); code != http.StatusOK || expr.Status != models.StatusCancelled || expr.Result != 0 {
+		t.Errorf("Ожидалось отмененное выражение без результата, получено: %d, %+v", code, expr)
+	}
+}
+
+func TestCancelExpressionErrors(t *testing.T) {
+	app := newTestApp(t)
+	addExpression(t, app, `{"expression": "7"}`)
+	addExpression(t, app, `{"expression": "1 / 0"}`)
+	for _, task := range drainTasks(app) {
+		app.acceptResult(models.Result{ID: task.ID, ErrorCode: "DIVISION_BY_ZERO", Error: "деление на ноль", LeaseToken: task.LeaseToken})
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+	}{
+		{"Неизвестное выражение", "unknown", http.StatusNotFound},
+		{"Выражение вычислено", "id-1", http.StatusConflict},
+		{"Выражение завершилось ошибкой", "id-2", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _ := cancelExpression(t, app, tt.id); code != tt.wantStatus {
+				t.Errorf("Ожидаемый статус код: %d, получено: %d", tt.wantStatus, code)
+			}
+		})
+	}
+}
+
+func TestCancelExpressionStaysCancelled(t *testing.T) {
+	app := newTestApp(t)
+	addExpression(t, app, `{"expression": "2 + 3"}`)
+	tasks := drainTasks(app)
+	if code, _ := cancelExpression(t, app, "id-1"); code != http.StatusOK {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusOK, code)
+	}
+
+	// Запоздавшие выдача задачи и ошибка задачи не перезаписывают отмену
+	app.queued["id-1"] = struct{}{}
+	app.taskLeased(tasks[0])
+	app.saveFailure("id-1", CodeAttemptsExhausted, "задача не выполнена")
+	if expr, _ := app.expressions.Get("id-1"); expr.Status != models.StatusCancelled || expr.ErrorCode != "" {
+		t.Errorf("Ожидалось отмененное выражение, получено: %+v", expr)
+	}
+}
+
+func TestCancelExpressionNotifies(t *testing.T) {
+	app := newTestApp(t, WithConfig(&Config{WebhookSecret: "secret", WebhookBackoff: time.Millisecond, WebhookAllowPrivate: true}))
+	callbackURL, received := newReceiver(t, "secret", http.StatusOK)
+	body, _ := json.Marshal(map[string]string{"expression": "2 + 3", "callback_url": callbackURL})
+	addExpression(t, app, string(body))
+
+	events, unsubscribe := app.events.subscribe("id-1")
+	defer unsubscribe()
+	if code, _ := cancelExpression(t, app, "id-1"); code != http.StatusOK {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusOK, code)
+	}
+	if event := <-events; event.Type != models.EventStatus || event.Expression.Status != models.StatusCancelled {
+		t.Errorf("Ожидалось событие об отмене, получено: %+v", event)
+	}
+
+	waitDeliveries(t, app, "id-1", 1)
+	if got := received(); len(got) != 1 || got[0].Status != models.StatusCancelled {
+		t.Errorf("Ожидалось отмененное выражение у получателя, получено: %+v", got)
+	}
+}
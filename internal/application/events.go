@@ -0,0 +1,216 @@
+package application
+
+import (
+	"Second_sprint_final_task/internal/storage"
+	"Second_sprint_final_task/pkg/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	eventBufferSize = 64              // сколько событий ждут отправки одному подписчику
+	wsWriteTimeout  = 5 * time.Second // сколько ждать записи сообщения в WebSocket
+)
+
+// eventHub рассылает события выражений подписчикам потоков /events и /ws
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan models.ExpressionEvent]struct{} // по ID выражения
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[string]map[chan models.ExpressionEvent]struct{})}
+}
+
+// subscribe подписывает на события выражения. Канал закрывается вызовом cancel, а также
+// если подписчик не успевает читать события: тогда ему нужно подписаться заново
+func (h *eventHub) subscribe(expressionID string) (<-chan models.ExpressionEvent, func()) {
+	ch := make(chan models.ExpressionEvent, eventBufferSize)
+	h.mu.Lock()
+	if h.subs[expressionID] == nil {
+		h.subs[expressionID] = make(map[chan models.ExpressionEvent]struct{})
+	}
+	h.subs[expressionID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(expressionID, ch)
+	}
+}
+
+// remove отписывает канал и закрывает его. Вызывается под mu
+func (h *eventHub) remove(expressionID string, ch chan models.ExpressionEvent) {
+	if _, ok := h.subs[expressionID][ch]; !ok {
+		return
+	}
+	delete(h.subs[expressionID], ch)
+	if len(h.subs[expressionID]) == 0 {
+		delete(h.subs, expressionID)
+	}
+	close(ch)
+}
+
+// watched сообщает, есть ли у выражения подписчики
+func (h *eventHub) watched(expressionID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[expressionID]) > 0
+}
+
+// publish отправляет событие подписчикам выражения не блокируясь
+func (h *eventHub) publish(expressionID string, event models.ExpressionEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[expressionID] {
+		select {
+		case ch <- event:
+		default:
+			h.remove(expressionID, ch)
+		}
+	}
+}
+
+// publishExpression рассылает подписчикам текущее состояние выражения
+func (a *Application) publishExpression(expressionID string) {
+	if !a.events.watched(expressionID) {
+		return
+	}
+	expr, err := a.expressions.Get(expressionID)
+	if err != nil {
+		return
+	}
+	a.events.publish(expressionID, models.ExpressionEvent{Type: models.EventStatus, Expression: &expr})
+}
+
+// publishTask рассылает подписчикам результат вычисленной подзадачи выражения
+func (a *Application) publishTask(task models.Task, result models.Result) {
+	a.events.publish(task.ExpressionID, models.ExpressionEvent{Type: models.EventTask, Task: &task, Result: &result})
+}
+
+// isFinal сообщает, что выражение в этом статусе больше не изменится
+func isFinal(status string) bool {
+	return status == models.StatusCompleted || status == models.StatusFailed || status == models.StatusCancelled
+}
+
+// watchExpression подписывается на события выражения и возвращает его текущее состояние.
+// Подписка оформляется раньше чтения, чтобы не пропустить изменения между ними
+func (a *Application) watchExpression(w http.ResponseWriter, r *http.Request) (models.Expression, <-chan models.ExpressionEvent, func(), bool) {
+	id := mux.Vars(r)["id"]
+	events, cancel := a.events.subscribe(id)
+	expr, err := a.expressions.Get(id)
+	if err != nil {
+		cancel()
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "Выражение не найдено", http.StatusNotFound)
+		} else {
+			http.Error(w, "Ошибка при чтении выражения", http.StatusInternalServerError)
+		}
+		return models.Expression{}, nil, nil, false
+	}
+	return expr, events, cancel, true
+}
+
+// streamEvents отправляет через send текущее состояние выражения, а затем его события,
+// пока выражение не завершится. Поток прерывается отменой ctx, остановкой сервера
+// и если подписчик отстал от событий
+func (a *Application) streamEvents(ctx context.Context, expr models.Expression, events <-chan models.ExpressionEvent, send func(models.ExpressionEvent) error) {
+	if err := send(models.ExpressionEvent{Type: models.EventStatus, Expression: &expr}); err != nil || isFinal(expr.Status) {
+		return
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+			if event.Type == models.EventStatus && isFinal(event.Expression.Status) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		case <-a.drainCtx.Done():
+			return
+		}
+	}
+}
+
+// ExpressionEventsHandler передает изменения выражения как Server-Sent Events: первым событием
+// приходит текущее состояние, дальше — смены статуса и результаты подзадач. Поток закрывается,
+// когда выражение завершится
+func (a *Application) ExpressionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+	expr, events, cancel, ok := a.watchExpression(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	a.streamEvents(r.Context(), expr, events, func(event models.ExpressionEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+var upgrader = websocket.Upgrader{}
+
+// ExpressionWebSocketHandler передает те же события, что и ExpressionEventsHandler,
+// JSON-сообщениями по WebSocket
+func (a *Application) ExpressionWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	expr, events, cancel, ok := a.watchExpression(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту
+		return
+	}
+	defer ws.Close()
+
+	// Читаем соединение, чтобы заметить, что клиент его закрыл
+	ctx, stop := context.WithCancel(r.Context())
+	defer stop()
+	go func() {
+		defer stop()
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	a.streamEvents(ctx, expr, events, func(event models.ExpressionEvent) error {
+		ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return ws.WriteJSON(event)
+	})
+	// Клиент мог уже закрыть соединение, тогда ошибка не важна
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+}
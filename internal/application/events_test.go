@@ -0,0 +1,174 @@
+package application
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Second_sprint_final_task/pkg/calculation"
+	"Second_sprint_final_task/pkg/models"
+	"github.com/gorilla/websocket"
+)
+
+// Вспомогательная функция, описывающая событие для сравнения: тип и статус или операция
+func describeEvent(event models.ExpressionEvent) string {
+	if event.Type == models.EventTask {
+		return event.Type + " " + event.Task.Operation
+	}
+	return event.Type + " " + event.Expression.Status
+}
+
+// Вспомогательная функция, читающая следующее событие из потока SSE
+func readSSE(t *testing.T, scanner *bufio.Scanner) (models.ExpressionEvent, bool) {
+	t.Helper()
+	var event models.ExpressionEvent
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("Неверное событие %q: %v", data, err)
+		}
+		return event, true
+	}
+	return event, false
+}
+
+func TestExpressionEventsHandler(t *testing.T) {
+	app := newTestApp(t)
+	server := httptest.NewServer(app.Handler())
+	defer server.Close()
+	addExpression(t, app, `{"expression": "(1 + 2) * (3 + 4)"}`)
+	addExpression(t, app, `{"expression": "5"}`)
+
+	tests := []struct {
+		name       string
+		id         string
+		compute    bool
+		wantStatus int
+		wantEvents []string
+	}{
+		{
+			name:       "Вычисление выражения",
+			id:         "id-1",
+			compute:    true,
+			wantStatus: http.StatusOK,
+			wantEvents: []string{"status pending", "status processing", "task +", "task +", "task *", "status completed"},
+		},
+		{
+			name:       "Уже вычисленное выражение",
+			id:         "id-5",
+			wantStatus: http.StatusOK,
+			wantEvents: []string{"status completed"},
+		},
+		{
+			name:       "Неизвестное выражение",
+			id:         "unknown",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + "/api/v1/expressions/" + tt.id + "/events")
+			if err != nil {
+				t.Fatalf("Ошибка при подключении к потоку: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Ожидаемый статус код: %d, получено: %d", tt.wantStatus, resp.StatusCode)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Ожидаемый Content-Type: text/event-stream, получено: %s", ct)
+			}
+
+			scanner := bufio.NewScanner(resp.Body)
+			var got []string
+			var last models.ExpressionEvent
+			for event, ok := readSSE(t, scanner); ok; event, ok = readSSE(t, scanner) {
+				got = append(got, describeEvent(event))
+				last = event
+				if len(got) == 1 && tt.compute {
+					// Первое событие пришло, значит подписка оформлена: можно вычислять
+					computeTasks(t, app, 3)
+				}
+			}
+
+			if strings.Join(got, ", ") != strings.Join(tt.wantEvents, ", ") {
+				t.Errorf("Ожидаемые события: %v, получено: %v", tt.wantEvents, got)
+			}
+			if tt.compute && last.Expression != nil && last.Expression.Result != 21 {
+				t.Errorf("Ожидаемый результат: 21, получено: %v", last.Expression.Result)
+			}
+		})
+	}
+}
+
+func TestExpressionWebSocketHandler(t *testing.T) {
+	app := newTestApp(t)
+	server := httptest.NewServer(app.Handler())
+	defer server.Close()
+	addExpression(t, app, `{"expression": "1 / 0"}`)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/expressions/id-1/ws"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Ошибка при подключении к WebSocket: %v", err)
+	}
+	defer ws.Close()
+
+	var event models.ExpressionEvent
+	if err := ws.ReadJSON(&event); err != nil || describeEvent(event) != "status pending" {
+		t.Fatalf("Ожидалось текущее состояние выражения, получено: %+v, ошибка: %v", event, err)
+	}
+
+	// Ошибка вычисления завершает выражение без события подзадачи
+	task := drainTasks(app)[0]
+	app.acceptResult(models.Result{ID: task.ID, ErrorCode: calculation.CodeDivisionByZero, Error: "деление на ноль", LeaseToken: task.LeaseToken})
+	var got []string
+	for {
+		var event models.ExpressionEvent
+		if err := ws.ReadJSON(&event); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("Ожидалось штатное закрытие соединения, получено: %v", err)
+			}
+			break
+		}
+		got = append(got, describeEvent(event))
+	}
+	if strings.Join(got, ", ") != "status processing, status failed" {
+		t.Errorf("Ожидаемые события: [status processing status failed], получено: %v", got)
+	}
+
+	if _, _, err := websocket.DefaultDialer.Dial(strings.TrimSuffix(url, "id-1/ws")+"unknown/ws", nil); err != websocket.ErrBadHandshake {
+		t.Errorf("Ожидался отказ для неизвестного выражения, получено: %v", err)
+	}
+}
+
+func TestEventHubSlowSubscriber(t *testing.T) {
+	hub := newEventHub()
+	events, cancel := hub.subscribe("expr")
+	for i := 0; i <= eventBufferSize; i++ {
+		hub.publish("expr", models.ExpressionEvent{Type: models.EventTask})
+	}
+
+	// Отставший подписчик отписан: после накопленных событий канал закрыт
+	count := 0
+	for range events {
+		count++
+	}
+	if count != eventBufferSize {
+		t.Errorf("Ожидалось событий: %d, получено: %d", eventBufferSize, count)
+	}
+	if hub.watched("expr") {
+		t.Error("Ожидалось, что у выражения не останется подписчиков")
+	}
+	cancel()
+}
@@ -0,0 +1,149 @@
+package application
+
+import (
+	"Second_sprint_final_task/internal/grpcapi"
+	"Second_sprint_final_task/internal/orchestrator"
+	"Second_sprint_final_task/pkg/models"
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"time"
+)
+
+// GRPCServer возвращает gRPC-сервер со службой агентов. Служба работает с той же очередью задач,
+// что и HTTP-обработчики /internal/*
+func (a *Application) GRPCServer() *grpc.Server {
+	srv := grpc.NewServer()
+	grpcapi.RegisterTaskServiceServer(srv, &taskService{a: a})
+	return srv
+}
+
+// stopGRPC ждет завершения вызовов gRPC-службы, а после отмены ctx прерывает их
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+	}
+}
+
+// taskService — реализация grpcapi.TaskServiceServer
+type taskService struct {
+	grpcapi.UnimplementedTaskServiceServer
+	a *Application
+}
+
+func (s *taskService) Register(_ context.Context, req *grpcapi.Agent) (*grpcapi.RegisterResponse, error) {
+	agent := grpcapi.AgentFromProto(req)
+	if agent.ID == "" || agent.ComputingPower <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Нужны ID агента и положительная вычислительная мощность")
+	}
+	s.a.orchestrator.Register(agent)
+	return &grpcapi.RegisterResponse{
+		Id:                  agent.ID,
+		HeartbeatIntervalMs: s.a.orchestrator.HeartbeatInterval().Milliseconds(),
+	}, nil
+}
+
+func (s *taskService) Heartbeat(_ context.Context, req *grpcapi.HeartbeatRequest) (*grpcapi.HeartbeatResponse, error) {
+	cancelled, err := s.a.orchestrator.Heartbeat(req.GetAgentId(), grpcapi.WorkersFromProto(req.GetWorkers()))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "Агент не зарегистрирован")
+	}
+	return &grpcapi.HeartbeatResponse{CancelledTasks: cancelled}, nil
+}
+
+func (s *taskService) Deregister(_ context.Context, req *grpcapi.DeregisterRequest) (*grpcapi.Empty, error) {
+	if err := s.a.orchestrator.Deregister(req.GetAgentId()); err != nil {
+		return nil, status.Error(codes.NotFound, "Агент не зарегистрирован")
+	}
+	return &grpcapi.Empty{}, nil
+}
+
+// GetTask выдает задачу как GET /internal/task: при пустой очереди ждет ее не дольше wait_ms.
+// Если задача так и не появилась, возвращает NOT_FOUND
+func (s *taskService) GetTask(ctx context.Context, req *grpcapi.GetTaskRequest) (*grpcapi.Task, error) {
+	if s.a.drainCtx.Err() != nil {
+		return nil, status.Error(codes.Unavailable, "Сервер останавливается")
+	}
+	if req.GetWaitMs() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Неверное время ожидания")
+	}
+
+	var task models.Task
+	var ok bool
+	if req.GetWaitMs() == 0 {
+		task, ok = s.a.orchestrator.Next(req.GetAgentId())
+	} else {
+		task, ok = s.a.waitTask(ctx, req.GetAgentId(), time.Duration(req.GetWaitMs())*time.Millisecond)
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Нет доступных задач")
+	}
+	return grpcapi.TaskToProto(task), nil
+}
+
+// SubmitResult принимает результат как POST /internal/result: NOT_FOUND — задача неизвестна,
+// FAILED_PRECONDITION — задача не выдана этому агенту или аренда истекла
+func (s *taskService) SubmitResult(_ context.Context, result *grpcapi.Result) (*grpcapi.Empty, error) {
+	if err := resultStatus(s.a.acceptResult(grpcapi.ResultFromProto(result))); err != nil {
+		return nil, err
+	}
+	return &grpcapi.Empty{}, nil
+}
+
+// resultStatus переводит ошибку приема результата в статус gRPC
+func resultStatus(err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownTask):
+		return status.Error(codes.NotFound, "Задача не найдена")
+	case errors.Is(err, orchestrator.ErrLeaseMismatch):
+		return status.Error(codes.FailedPrecondition, "Задача не выдана этому агенту или аренда истекла")
+	}
+	return nil
+}
+
+// TaskStream принимает результаты задач агента и в ответ на каждое сообщение присылает
+// следующую задачу, как только она появится в очереди. Поток завершается, когда агент
+// закрывает его, или с кодом UNAVAILABLE при остановке сервера
+func (s *taskService) TaskStream(stream grpc.BidiStreamingServer[grpcapi.StreamRequest, grpcapi.Task]) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.GetResult() != nil {
+			// Отклоненный результат не прерывает поток: агент просто получит следующую задачу
+			s.a.acceptResult(grpcapi.ResultFromProto(req.GetResult()))
+		}
+
+		for {
+			if s.a.drainCtx.Err() != nil {
+				return status.Error(codes.Unavailable, "Сервер останавливается")
+			}
+			if err := stream.Context().Err(); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			task, ok := s.a.waitTask(stream.Context(), req.GetAgentId(), maxTaskWait)
+			if !ok {
+				continue
+			}
+			if err := stream.Send(grpcapi.TaskToProto(task)); err != nil {
+				// Аренда задачи истечет, и она вернется в очередь
+				return err
+			}
+			break
+		}
+	}
+}
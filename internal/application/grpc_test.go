@@ -0,0 +1,160 @@
+package application
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Second_sprint_final_task/internal/grpcapi"
+	"Second_sprint_final_task/pkg/calculation"
+	"Second_sprint_final_task/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Вспомогательная функция, подключающая клиента к gRPC-службе сервера через bufconn
+func newGRPCClient(t *testing.T, app *Application) grpcapi.TaskServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := app.GRPCServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Ошибка при создании клиента: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return grpcapi.NewTaskServiceClient(cc)
+}
+
+// Вспомогательная функция, добавляющая выражение через HTTP API
+func addExpression(t *testing.T, app *Application, body string) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Ожидаемый статус код: %d, получено: %d", http.StatusCreated, rr.Code)
+	}
+}
+
+func TestGRPCAgentCalls(t *testing.T) {
+	app := newTestApp(t)
+	client := newGRPCClient(t, app)
+	ctx := context.Background()
+
+	if _, err := client.Register(ctx, &grpcapi.Agent{Id: "agent1"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Ожидался код %v, получено: %v", codes.InvalidArgument, err)
+	}
+	resp, err := client.Register(ctx, &grpcapi.Agent{Id: "agent1", ComputingPower: 1})
+	if err != nil || resp.HeartbeatIntervalMs != 5000 {
+		t.Fatalf("Неожиданный ответ на регистрацию: %+v, ошибка: %v", resp, err)
+	}
+	if _, err := client.Heartbeat(ctx, &grpcapi.HeartbeatRequest{AgentId: "agent1"}); err != nil {
+		t.Errorf("Неожиданная ошибка heartbeat: %v", err)
+	}
+	if _, err := client.Heartbeat(ctx, &grpcapi.HeartbeatRequest{AgentId: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Ожидался код %v, получено: %v", codes.NotFound, err)
+	}
+
+	// Пустая очередь: и без ожидания, и после него задачи нет
+	for _, wait := range []int64{0, 20} {
+		if _, err := client.GetTask(ctx, &grpcapi.GetTaskRequest{AgentId: "agent1", WaitMs: wait}); status.Code(err) != codes.NotFound {
+			t.Errorf("Ожидался код %v при ожидании %d мс, получено: %v", codes.NotFound, wait, err)
+		}
+	}
+
+	addExpression(t, app, `{"expression": "2 + 3"}`)
+	task, err := client.GetTask(ctx, &grpcapi.GetTaskRequest{AgentId: "agent1", WaitMs: 1000})
+	if err != nil || task.Operation != "+" || task.Attempt != 1 || task.LeaseDeadline == nil {
+		t.Fatalf("Ожидалась задача 2 + 3, получено: %+v, ошибка: %v", task, err)
+	}
+	if load := app.orchestrator.Agents()[0].Load; load != 1 {
+		t.Errorf("Ожидаемая нагрузка агента: 1, получено: %d", load)
+	}
+
+	if _, err := client.SubmitResult(ctx, &grpcapi.Result{Id: "unknown", Result: 5}); status.Code(err) != codes.NotFound {
+		t.Errorf("Ожидался код %v, получено: %v", codes.NotFound, err)
+	}
+	if _, err := client.SubmitResult(ctx, &grpcapi.Result{Id: task.Id, LeaseToken: "forged", Result: 5}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Ожидался код %v, получено: %v", codes.FailedPrecondition, err)
+	}
+	if _, err := client.SubmitResult(ctx, &grpcapi.Result{Id: task.Id, LeaseToken: task.LeaseToken, Result: 5}); err != nil {
+		t.Fatalf("Ошибка при отправке результата: %v", err)
+	}
+	expr, err := app.expressions.Get(task.ExpressionId)
+	if err != nil || expr.Status != models.StatusCompleted || expr.Result != 5 {
+		t.Errorf("Ожидалось вычисленное выражение с результатом 5, получено: %+v", expr)
+	}
+
+	if _, err := client.Deregister(ctx, &grpcapi.DeregisterRequest{AgentId: "agent1"}); err != nil {
+		t.Errorf("Ошибка при снятии агента с учета: %v", err)
+	}
+	if _, err := client.Deregister(ctx, &grpcapi.DeregisterRequest{AgentId: "agent1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Ожидался код %v, получено: %v", codes.NotFound, err)
+	}
+}
+
+func TestGRPCTaskStream(t *testing.T) {
+	app := newTestApp(t)
+	client := newGRPCClient(t, app)
+	addExpression(t, app, `{"expression": "(1 + 2) * (3 + 4)"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.TaskStream(ctx)
+	if err != nil {
+		t.Fatalf("Ошибка при открытии потока: %v", err)
+	}
+
+	// Вычисляем задачи по одной, отправляя каждый результат вместе с запросом следующей задачи
+	req := &grpcapi.StreamRequest{AgentId: "agent1"}
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Ошибка при отправке: %v", err)
+		}
+		task, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Ошибка при получении задачи: %v", err)
+		}
+		value, err := calculation.Apply(task.Operation, task.Arg1, task.Arg2)
+		if err != nil {
+			t.Fatalf("Ошибка при вычислении задачи %+v: %v", task, err)
+		}
+		req = &grpcapi.StreamRequest{AgentId: "agent1", Result: &grpcapi.Result{Id: task.Id, Result: value, LeaseToken: task.LeaseToken}}
+	}
+	if err := stream.Send(req); err != nil {
+		t.Fatalf("Ошибка при отправке: %v", err)
+	}
+
+	// Результат последней задачи сервер обрабатывает после получения сообщения
+	deadline := time.Now().Add(time.Second)
+	for {
+		expr, _ := app.expressions.Get("id-1")
+		if expr.Status == models.StatusCompleted {
+			if expr.Result != 21 {
+				t.Errorf("Ожидаемый результат: 21, получено: %v", expr.Result)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Выражение не вычислено: %+v", expr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// При остановке сервера ожидающий поток завершается
+	app.startDrain()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Ожидался код %v, получено: %v", codes.Unavailable, err)
+	}
+}
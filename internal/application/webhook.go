@@ -0,0 +1,237 @@
+package application
+
+import (
+	"Second_sprint_final_task/pkg/models"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultWebhookAttempts = 5                // сколько раз отправлять выражение на callback_url по умолчанию
+	defaultWebhookBackoff  = time.Second      // пауза перед первым повтором отправки по умолчанию
+	defaultWebhookTimeout  = 10 * time.Second // таймаут запроса к получателю по умолчанию
+	maxWebhookBackoff      = 5 * time.Minute  // наибольшая пауза между повторами отправки
+)
+
+// SignatureHeader — заголовок с подписью тела запроса на callback_url: "sha256=" и HMAC-SHA256
+// тела в hex с ключом WEBHOOK_SECRET
+const SignatureHeader = "X-Signature-256"
+
+// Sign возвращает значение SignatureHeader для тела body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// errPrivateCallback — callback_url указывает на localhost или адрес во внутренней сети
+var errPrivateCallback = errors.New("адреса localhost и внутренней сети запрещены")
+
+// checkCallbackURL проверяет, что на адрес можно отправить выражение. Без WEBHOOK_SECRET
+// получатель не может проверить, что запрос пришел от сервера, поэтому callback_url не принимается.
+// Адреса localhost и внутренней сети запрещены, чтобы через callback_url нельзя было обратиться
+// к самому серверу (например, к /internal/*) или к соседним службам
+func (a *Application) checkCallbackURL(raw string) error {
+	if a.config.WebhookSecret == "" {
+		return requestError("callback_url не поддерживается: на сервере не задан WEBHOOK_SECRET")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return requestError("Неверный callback_url: нужен адрес http или https")
+	}
+	if !a.config.WebhookAllowPrivate && privateHost(u.Hostname()) {
+		return requestError("Неверный callback_url: " + errPrivateCallback.Error())
+	}
+	return nil
+}
+
+// privateHost сообщает, что имя или IP-адрес узла ведет на localhost или во внутреннюю сеть.
+// Имена, которые разрешаются в такие адреса, отсекает denyPrivateDial при подключении
+func privateHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && privateIP(ip)
+}
+
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// denyPrivateDial не дает подключиться к адресу во внутренней сети, в который разрешилось
+// имя получателя или адрес, на который он перенаправил запрос
+func denyPrivateDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && privateIP(ip) {
+		return errPrivateCallback
+	}
+	return nil
+}
+
+// newWebhookClient создает HTTP-клиент для отправки выражений на callback_url
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: denyPrivateDial}
+		transport.DialContext = dialer.DialContext
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func (a *Application) webhookAttempts() int {
+	return cmp.Or(a.config.WebhookMaxAttempts, defaultWebhookAttempts)
+}
+
+// undelivered сообщает, что завершенное выражение еще нужно отправить на его callback_url
+func undelivered(expr models.Expression, attempts int) bool {
+	if expr.CallbackURL == "" || len(expr.Deliveries) >= attempts {
+		return false
+	}
+	for _, d := range expr.Deliveries {
+		if d.Delivered {
+			return false
+		}
+	}
+	return true
+}
+
+// notify отправляет завершенное выражение на его callback_url в фоне
+func (a *Application) notify(expressionID string) {
+	expr, err := a.expressions.Get(expressionID)
+	if err != nil || expr.CallbackURL == "" {
+		return
+	}
+	a.startDelivery(expr)
+}
+
+// startDelivery запускает доставку выражения в фоне, если сервер не остановлен.
+// Close дожидается запущенных доставок, чтобы они не писали в закрытое хранилище
+func (a *Application) startDelivery(expr models.Expression) {
+	a.deliveryMutex.Lock()
+	defer a.deliveryMutex.Unlock()
+	select {
+	case <-a.stop:
+		return
+	default:
+	}
+	a.deliveries.Add(1)
+	go func() {
+		defer a.deliveries.Done()
+		a.deliver(expr)
+	}()
+}
+
+// deliver отправляет выражение на callback_url, пока получатель не ответит 2xx или не кончатся
+// попытки. Перед повтором ждет паузу, которая удваивается с каждой попыткой. Каждая попытка
+// сохраняется в Deliveries выражения. Остановка сервера прерывает доставку
+func (a *Application) deliver(expr models.Expression) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Тело одно для всех попыток, чтобы получатель мог узнать повтор
+	first := len(expr.Deliveries) + 1
+	expr.Deliveries = nil
+	body, err := json.Marshal(expr)
+	if err != nil {
+		log.Printf("Ошибка при подготовке выражения %s к отправке: %v", expr.ID, err)
+		return
+	}
+
+	attempts := a.webhookAttempts()
+	for attempt := first; attempt <= attempts; attempt++ {
+		if attempt > 1 && !sleep(ctx, a.webhookBackoff(attempt-1)) {
+			return
+		}
+		delivery := a.post(ctx, expr.CallbackURL, body)
+		if ctx.Err() != nil {
+			// Попытку прервала остановка сервера, после перезапуска она повторится
+			return
+		}
+		delivery.Attempt = attempt
+		if err := a.expressions.AddDelivery(expr.ID, delivery); err != nil {
+			log.Printf("Ошибка при сохранении попытки доставки выражения %s: %v", expr.ID, err)
+		}
+		if delivery.Delivered {
+			log.Printf("Выражение %s отправлено на %s", expr.ID, expr.CallbackURL)
+			return
+		}
+		log.Printf("Попытка %d отправить выражение %s на %s не удалась: %s", attempt, expr.ID, expr.CallbackURL, delivery.Error)
+	}
+	log.Printf("Выражение %s не отправлено на %s за %d попыток", expr.ID, expr.CallbackURL, attempts)
+}
+
+// webhookBackoff возвращает паузу перед повтором с номером retry (с единицы). Пауза удваивается,
+// пока не достигнет maxWebhookBackoff, поэтому большая WEBHOOK_BACKOFF_MS не переполняет сдвиг
+func (a *Application) webhookBackoff(retry int) time.Duration {
+	d := cmp.Or(a.config.WebhookBackoff, defaultWebhookBackoff)
+	for i := 1; i < retry && d > 0 && d < maxWebhookBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxWebhookBackoff)
+}
+
+// sleep ждет d и возвращает false, если ctx отменен раньше
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// post выполняет одну попытку отправки тела на адрес получателя
+func (a *Application) post(ctx context.Context, callbackURL string, body []byte) models.Delivery {
+	delivery := models.Delivery{Time: a.now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.WebhookSecret != "" {
+		req.Header.Set(SignatureHeader, Sign(a.config.WebhookSecret, body))
+	}
+
+	resp, err := a.webhooks.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	delivery.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Delivered {
+		delivery.Error = fmt.Sprintf("получатель ответил %s", resp.Status)
+	}
+	return delivery
+}
@@ -0,0 +1,273 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"Second_sprint_final_task/internal/storage"
+	"Second_sprint_final_task/pkg/models"
+)
+
+// Вспомогательная функция, запускающая получателя выражений. Получатель проверяет подпись,
+// если задан secret, отвечает статусами из statuses по очереди, а затем последним из них,
+// и запоминает принятые выражения
+func newReceiver(t *testing.T, secret string, statuses ...int) (string, func() []models.Expression) {
+	t.Helper()
+	var mu sync.Mutex
+	var received []models.Expression
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(SignatureHeader); secret != "" && got != Sign(secret, body) {
+			t.Errorf("Неверная подпись: %q", got)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		code := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		if code == http.StatusOK {
+			var expr models.Expression
+			json.Unmarshal(body, &expr)
+			received = append(received, expr)
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func() []models.Expression {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]models.Expression(nil), received...)
+	}
+}
+
+// Вспомогательная функция, ждущая, пока у выражения наберется n попыток доставки
+func waitDeliveries(t *testing.T, app *Application, id string, n int) []models.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		expr, _ := app.expressions.Get(id)
+		if len(expr.Deliveries) >= n {
+			return expr.Deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Ожидалось попыток доставки: %d, получено: %+v", n, expr.Deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		statuses   []int
+		wantStatus string
+		wantCodes  []int
+	}{
+		{
+			name:       "Доставка с первой попытки",
+			expression: "2 + 3",
+			statuses:   []int{http.StatusOK},
+			wantStatus: models.StatusCompleted,
+			wantCodes:  []int{http.StatusOK},
+		},
+		{
+			name:       "Повтор после ошибки получателя",
+			expression: "2 + 3",
+			statuses:   []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK},
+			wantStatus: models.StatusCompleted,
+			wantCodes:  []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK},
+		},
+		{
+			name:       "Ошибка вычисления",
+			expression: "1 / 0",
+			statuses:   []int{http.StatusOK},
+			wantStatus: models.StatusFailed,
+			wantCodes:  []int{http.StatusOK},
+		},
+		{
+			name:       "Выражение без операций",
+			expression: "5",
+			statuses:   []int{http.StatusOK},
+			wantStatus: models.StatusCompleted,
+			wantCodes:  []int{http.StatusOK},
+		},
+		{
+			name:       "Попытки кончились",
+			expression: "2 + 3",
+			statuses:   []int{http.StatusServiceUnavailable},
+			wantStatus: models.StatusCompleted,
+			wantCodes:  []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, WithConfig(&Config{WebhookSecret: "secret", WebhookMaxAttempts: 3, WebhookBackoff: time.Millisecond, WebhookAllowPrivate: true}))
+			callbackURL, received := newReceiver(t, "secret", tt.statuses...)
+			body, _ := json.Marshal(map[string]string{"expression": tt.expression, "callback_url": callbackURL})
+			addExpression(t, app, string(body))
+
+			for _, task := range drainTasks(app) {
+				result := models.Result{ID: task.ID, Result: task.Arg1 + task.Arg2, LeaseToken: task.LeaseToken}
+				if task.Operation == "/" {
+					result = models.Result{ID: task.ID, ErrorCode: "DIVISION_BY_ZERO", Error: "деление на ноль", LeaseToken: task.LeaseToken}
+				}
+				app.acceptResult(result)
+			}
+
+			deliveries := waitDeliveries(t, app, "id-1", len(tt.wantCodes))
+			if len(deliveries) != len(tt.wantCodes) {
+				t.Fatalf("Ожидаемое число попыток: %d, получено: %+v", len(tt.wantCodes), deliveries)
+			}
+			for i, d := range deliveries {
+				if d.Attempt != i+1 || d.StatusCode != tt.wantCodes[i] || d.Delivered != (d.StatusCode == http.StatusOK) {
+					t.Errorf("Неожиданная попытка доставки %d: %+v", i+1, d)
+				}
+			}
+
+			got := received()
+			if tt.wantCodes[len(tt.wantCodes)-1] != http.StatusOK {
+				if len(got) != 0 {
+					t.Errorf("Ожидалось, что выражение не будет принято, получено: %+v", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].ID != "id-1" || got[0].Status != tt.wantStatus || got[0].CallbackURL != callbackURL {
+				t.Errorf("Ожидалось принятое выражение в статусе %s, получено: %+v", tt.wantStatus, got)
+			}
+		})
+	}
+}
+
+func TestWebhookInvalidCallbackURL(t *testing.T) {
+	app := newTestApp(t, WithConfig(&Config{WebhookSecret: "secret"}))
+	tests := []struct {
+		name        string
+		callbackURL string
+		wantStatus  int
+	}{
+		{"Неверная схема", "ftp://example.com/hook", http.StatusBadRequest},
+		{"Относительный адрес", "/hook", http.StatusBadRequest},
+		{"Без узла", "http://", http.StatusBadRequest},
+		{"localhost", "http://localhost:8080/internal/result", http.StatusBadRequest},
+		{"Loopback", "http://127.0.0.1:8080/internal/result", http.StatusBadRequest},
+		{"Loopback IPv6", "http://[::1]/hook", http.StatusBadRequest},
+		{"Внутренняя сеть", "https://10.0.0.5/hook", http.StatusBadRequest},
+		{"Метаданные облака", "http://169.254.169.254/latest/meta-data", http.StatusBadRequest},
+		{"Внешний адрес", "https://billing.example.com/hooks/calc", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"expression": "2 + 3", "callback_url": tt.callbackURL})
+			rr := httptest.NewRecorder()
+			app.AddExpressionHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(string(body))))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Ожидаемый статус код для %q: %d, получено: %d", tt.callbackURL, tt.wantStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		base     time.Duration
+		retry    int
+		expected time.Duration
+	}{
+		{0, 1, defaultWebhookBackoff},
+		{time.Second, 3, 4 * time.Second},
+		{time.Second, 20, maxWebhookBackoff},
+		{time.Second, 100, maxWebhookBackoff},
+		// Сдвиг большой паузы переполнил бы time.Duration
+		{time.Hour, 20, maxWebhookBackoff},
+		{math.MaxInt64 / 2, 2, maxWebhookBackoff},
+	}
+	for _, tt := range tests {
+		app := &Application{config: &Config{WebhookBackoff: tt.base}}
+		if got := app.webhookBackoff(tt.retry); got != tt.expected {
+			t.Errorf("Пауза %v перед повтором %d: ожидалось %v, получено: %v", tt.base, tt.retry, tt.expected, got)
+		}
+	}
+}
+
+func TestWebhookRequiresSecret(t *testing.T) {
+	app := newTestApp(t, WithConfig(&Config{WebhookAllowPrivate: true}))
+	body := `{"expression": "2 + 3", "callback_url": "https://billing.example.com/hooks/calc"}`
+	rr := httptest.NewRecorder()
+	app.AddExpressionHandler(rr, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "WEBHOOK_SECRET") {
+		t.Errorf("Ожидался отказ без WEBHOOK_SECRET, получено: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestWebhookDeniesPrivateAddress(t *testing.T) {
+	// Имя получателя может разрешиться в адрес внутренней сети: такое подключение запрещено
+	callbackURL, received := newReceiver(t, "secret", http.StatusOK)
+	app := newTestApp(t, WithConfig(&Config{WebhookSecret: "secret"}))
+	delivery := app.post(context.Background(), callbackURL, []byte("{}"))
+	if delivery.Delivered || !strings.Contains(delivery.Error, errPrivateCallback.Error()) {
+		t.Errorf("Ожидался отказ в подключении, получено: %+v", delivery)
+	}
+	if got := received(); len(got) != 0 {
+		t.Errorf("Ожидалось, что получатель ничего не получит, получено: %+v", got)
+	}
+}
+
+func TestWebhookCloseWaitsForDeliveries(t *testing.T) {
+	// Получатель не отвечает, пока запрос не прервут
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	store := storage.NewMemoryStore()
+	app := newTestApp(t, WithStore(store), WithConfig(&Config{WebhookSecret: "secret", WebhookAllowPrivate: true}))
+	body, _ := json.Marshal(map[string]string{"expression": "7", "callback_url": server.URL})
+	addExpression(t, app, string(body))
+	<-started
+
+	// Close прерывает доставку и дожидается ее: попытка, прерванная остановкой, не сохраняется
+	app.Close()
+	if expr, _ := store.Get("id-1"); len(expr.Deliveries) != 0 {
+		t.Errorf("Ожидалось, что после Close доставка не запишет попытку, получено: %+v", expr.Deliveries)
+	}
+	// После Close новые доставки не запускаются
+	app.notify("id-1")
+	select {
+	case <-started:
+		t.Error("Ожидалось, что после Close выражение не будет отправлено")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWebhookResumeAfterRestart(t *testing.T) {
+	callbackURL, received := newReceiver(t, "", http.StatusOK)
+	store := storage.NewMemoryStore()
+	store.Create(models.Expression{ID: "done", Expression: "2 + 3", Status: models.StatusCompleted, Result: 5, CallbackURL: callbackURL,
+		Deliveries: []models.Delivery{{Attempt: 1, StatusCode: http.StatusBadGateway}}})
+	store.Create(models.Expression{ID: "delivered", Expression: "1 + 1", Status: models.StatusCompleted, Result: 2, CallbackURL: callbackURL,
+		Deliveries: []models.Delivery{{Attempt: 1, StatusCode: http.StatusOK, Delivered: true}}})
+
+	app := newTestApp(t, WithStore(store), WithConfig(&Config{WebhookBackoff: time.Millisecond, WebhookAllowPrivate: true}))
+	deliveries := waitDeliveries(t, app, "done", 2)
+	if deliveries[1].Attempt != 2 || !deliveries[1].Delivered {
+		t.Errorf("Ожидалась успешная вторая попытка, получено: %+v", deliveries)
+	}
+	if got := received(); len(got) != 1 || got[0].ID != "done" || len(got[0].Deliveries) != 0 {
+		t.Errorf("Ожидалось, что повторно отправится только выражение done, получено: %+v", got)
+	}
+}
@@ -0,0 +1,117 @@
+// Package grpcapi — gRPC-служба обмена задачами между оркестратором и агентами.
+// Сообщения и служба сгенерированы из calculator.proto; функции этого файла переводят
+// сообщения в типы models, с которыми работают оркестратор и агент, и обратно
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative calculator.proto
+
+import (
+	"Second_sprint_final_task/pkg/models"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func AgentToProto(agent models.Agent) *Agent {
+	return &Agent{
+		Id:             agent.ID,
+		ComputingPower: int32(agent.ComputingPower),
+		Version:        agent.Version,
+		Capabilities:   agent.Capabilities,
+	}
+}
+
+func AgentFromProto(agent *Agent) models.Agent {
+	return models.Agent{
+		ID:             agent.GetId(),
+		ComputingPower: int(agent.GetComputingPower()),
+		Version:        agent.GetVersion(),
+		Capabilities:   agent.GetCapabilities(),
+	}
+}
+
+func WorkersToProto(workers []models.WorkerStats) []*WorkerStats {
+	if workers == nil {
+		return nil
+	}
+	out := make([]*WorkerStats, len(workers))
+	for i, w := range workers {
+		out[i] = &WorkerStats{Id: int32(w.ID), Processed: w.Processed, Failed: w.Failed, Busy: w.Busy}
+	}
+	return out
+}
+
+func WorkersFromProto(workers []*WorkerStats) []models.WorkerStats {
+	if workers == nil {
+		return nil
+	}
+	out := make([]models.WorkerStats, len(workers))
+	for i, w := range workers {
+		out[i] = models.WorkerStats{ID: int(w.GetId()), Processed: w.GetProcessed(), Failed: w.GetFailed(), Busy: w.GetBusy()}
+	}
+	return out
+}
+
+func TaskToProto(task models.Task) *Task {
+	t := &Task{
+		Id:           task.ID,
+		ExpressionId: task.ExpressionID,
+		Type:         task.Type,
+		Arg1:         task.Arg1,
+		Arg2:         task.Arg2,
+		Operation:    task.Operation,
+		Function:     task.Function,
+		Args:         task.Args,
+		Precision:    task.Precision,
+		DecimalArgs:  task.DecimalArgs,
+		Attempt:      int32(task.Attempt),
+		LeaseToken:   task.LeaseToken,
+	}
+	if !task.LeaseDeadline.IsZero() {
+		t.LeaseDeadline = timestamppb.New(task.LeaseDeadline)
+	}
+	return t
+}
+
+func TaskFromProto(task *Task) models.Task {
+	t := models.Task{
+		ID:           task.GetId(),
+		ExpressionID: task.GetExpressionId(),
+		Type:         task.GetType(),
+		Arg1:         task.GetArg1(),
+		Arg2:         task.GetArg2(),
+		Operation:    task.GetOperation(),
+		Function:     task.GetFunction(),
+		Args:         task.GetArgs(),
+		Precision:    task.GetPrecision(),
+		DecimalArgs:  task.GetDecimalArgs(),
+		Attempt:      int(task.GetAttempt()),
+		LeaseToken:   task.GetLeaseToken(),
+	}
+	if task.GetLeaseDeadline() != nil {
+		t.LeaseDeadline = task.GetLeaseDeadline().AsTime()
+	}
+	return t
+}
+
+func ResultToProto(result models.Result) *Result {
+	return &Result{
+		Id:           result.ID,
+		ExpressionId: result.ExpressionID,
+		Result:       result.Result,
+		Decimal:      result.Decimal,
+		ErrorCode:    result.ErrorCode,
+		Error:        result.Error,
+		LeaseToken:   result.LeaseToken,
+	}
+}
+
+func ResultFromProto(result *Result) models.Result {
+	return models.Result{
+		ID:           result.GetId(),
+		ExpressionID: result.GetExpressionId(),
+		Result:       result.GetResult(),
+		Decimal:      result.GetDecimal(),
+		ErrorCode:    result.GetErrorCode(),
+		Error:        result.GetError(),
+		LeaseToken:   result.GetLeaseToken(),
+	}
+}
@@ -0,0 +1,170 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"Second_sprint_final_task/pkg/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// echoService отвечает на вызовы предсказуемыми значениями
+type echoService struct {
+	UnimplementedTaskServiceServer
+}
+
+func (echoService) Register(_ context.Context, agent *Agent) (*RegisterResponse, error) {
+	return &RegisterResponse{Id: agent.GetId(), HeartbeatIntervalMs: 1000}, nil
+}
+
+func (echoService) Heartbeat(_ context.Context, req *HeartbeatRequest) (*HeartbeatResponse, error) {
+	if req.GetAgentId() != "agent1" {
+		return nil, status.Error(codes.NotFound, "агент не зарегистрирован")
+	}
+	return &HeartbeatResponse{CancelledTasks: []string{"task1"}}, nil
+}
+
+func (echoService) Deregister(context.Context, *DeregisterRequest) (*Empty, error) {
+	return &Empty{}, nil
+}
+
+func (echoService) GetTask(_ context.Context, req *GetTaskRequest) (*Task, error) {
+	return &Task{Id: req.GetAgentId(), Arg1: float64(req.GetWaitMs()), Operation: "+"}, nil
+}
+
+func (echoService) SubmitResult(_ context.Context, result *Result) (*Empty, error) {
+	return &Empty{}, nil
+}
+
+// TaskStream на каждое сообщение отвечает задачей с ID присланного результата
+func (echoService) TaskStream(stream grpc.BidiStreamingServer[StreamRequest, Task]) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		task := &Task{Id: "first"}
+		if req.GetResult() != nil {
+			task.Id = req.GetResult().GetId()
+		}
+		if err := stream.Send(task); err != nil {
+			return err
+		}
+	}
+}
+
+// Вспомогательная функция, подключающая клиента к службе через bufconn
+func newTestClient(t *testing.T, srv TaskServiceServer) TaskServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterTaskServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Ошибка при создании клиента: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return NewTaskServiceClient(cc)
+}
+
+func TestUnaryCalls(t *testing.T) {
+	client := newTestClient(t, echoService{})
+	ctx := context.Background()
+
+	resp, err := client.Register(ctx, &Agent{Id: "agent1", ComputingPower: 1})
+	if err != nil || resp.GetId() != "agent1" || resp.HeartbeatIntervalMs != 1000 {
+		t.Errorf("Неожиданный ответ на регистрацию: %+v, ошибка: %v", resp, err)
+	}
+
+	if hb, err := client.Heartbeat(ctx, &HeartbeatRequest{AgentId: "agent1"}); err != nil || !reflect.DeepEqual(hb.CancelledTasks, []string{"task1"}) {
+		t.Errorf("Неожиданный ответ на heartbeat: %+v, ошибка: %v", hb, err)
+	}
+	if _, err := client.Heartbeat(ctx, &HeartbeatRequest{AgentId: "agent2"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Ожидался код %v, получено: %v", codes.NotFound, err)
+	}
+
+	task, err := client.GetTask(ctx, &GetTaskRequest{AgentId: "agent1", WaitMs: 30})
+	expected := models.Task{ID: "agent1", Arg1: 30, Operation: "+"}
+	if err != nil || !reflect.DeepEqual(TaskFromProto(task), expected) {
+		t.Errorf("Ожидаемая задача: %+v, получено: %+v, ошибка: %v", expected, task, err)
+	}
+
+	if _, err := client.SubmitResult(ctx, &Result{Id: "task1", Result: 3}); err != nil {
+		t.Errorf("Неожиданная ошибка отправки результата: %v", err)
+	}
+}
+
+func TestTaskStream(t *testing.T) {
+	client := newTestClient(t, echoService{})
+
+	stream, err := client.TaskStream(context.Background())
+	if err != nil {
+		t.Fatalf("Ошибка при открытии потока: %v", err)
+	}
+
+	requests := []struct {
+		req      *StreamRequest
+		expected string
+	}{
+		{&StreamRequest{AgentId: "agent1"}, "first"},
+		{&StreamRequest{AgentId: "agent1", Result: &Result{Id: "task1"}}, "task1"},
+		{&StreamRequest{AgentId: "agent1", Result: &Result{Id: "task2"}}, "task2"},
+	}
+	for _, tt := range requests {
+		if err := stream.Send(tt.req); err != nil {
+			t.Fatalf("Ошибка при отправке: %v", err)
+		}
+		task, err := stream.Recv()
+		if err != nil || task.GetId() != tt.expected {
+			t.Errorf("Ожидалась задача %s, получено: %+v, ошибка: %v", tt.expected, task, err)
+		}
+	}
+
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Ожидалось завершение потока, получено: %v", err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	deadline := time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)
+	tasks := []models.Task{
+		{ID: "task1", ExpressionID: "expr1", Arg1: 1, Arg2: 2, Operation: "+", Attempt: 2, LeaseDeadline: deadline, LeaseToken: "token"},
+		{ID: "task2", Type: "function", Function: "max", Args: []float64{1, 2, 3}, Precision: "decimal", DecimalArgs: []string{"1", "2", "3"}},
+	}
+	for _, task := range tasks {
+		if got := TaskFromProto(TaskToProto(task)); !reflect.DeepEqual(got, task) {
+			t.Errorf("Ожидаемая задача: %+v, получено: %+v", task, got)
+		}
+	}
+
+	result := models.Result{ID: "task1", ExpressionID: "expr1", Decimal: "0.1", ErrorCode: "OVERFLOW", Error: "переполнение", LeaseToken: "token"}
+	if got := ResultFromProto(ResultToProto(result)); !reflect.DeepEqual(got, result) {
+		t.Errorf("Ожидаемый результат: %+v, получено: %+v", result, got)
+	}
+
+	agent := models.Agent{ID: "agent1", ComputingPower: 4, Version: "1.0", Capabilities: []string{"binary"}}
+	if got := AgentFromProto(AgentToProto(agent)); !reflect.DeepEqual(got, agent) {
+		t.Errorf("Ожидаемый агент: %+v, получено: %+v", agent, got)
+	}
+
+	workers := []models.WorkerStats{{ID: 1, Processed: 3, Failed: 1, Busy: true}}
+	if got := WorkersFromProto(WorkersToProto(workers)); !reflect.DeepEqual(got, workers) {
+		t.Errorf("Ожидаемые счетчики: %+v, получено: %+v", workers, got)
+	}
+}
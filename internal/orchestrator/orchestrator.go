@@ -2,41 +2,482 @@ package orchestrator
 
 import (
 	"Second_sprint_final_task/pkg/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"io"
 	"log"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 )
 
+var (
+	ErrUnknownAgent  = errors.New("агент не зарегистрирован")
+	ErrLeaseMismatch = errors.New("задача не выдана этому агенту или аренда истекла")
+)
+
+// Значения по умолчанию для Config
+const (
+	defaultQueueSize           = 100
+	defaultHeartbeatInterval   = 5 * time.Second
+	defaultMaxMissedHeartbeats = 3
+	defaultLeaseTimeout        = 30 * time.Second
+	defaultMaxAttempts         = 3
+)
+
+// AgentInfo — зарегистрированный агент и его состояние
 type AgentInfo struct {
-	ID             string
-	ComputingPower int
-	LastSeen       time.Time
+	models.Agent
+	LastSeen time.Time `json:"last_seen"`
+	Alive    bool      `json:"alive"`
+	Load     int       `json:"load"` // число выданных агенту задач, результат которых еще не получен
+	// Счетчики воркеров агента из последнего heartbeat
+	Workers []models.WorkerStats `json:"workers,omitempty"`
+}
+
+// Config — параметры оркестратора. Нулевые поля заменяются значениями по умолчанию
+type Config struct {
+	QueueSize           int
+	HeartbeatInterval   time.Duration     // как часто агенты должны отправлять heartbeat
+	MaxMissedHeartbeats int               // после стольких пропущенных heartbeat агент считается отключенным
+	LeaseTimeout        time.Duration     // сколько агент может держать задачу до возврата ее в очередь
+	MaxAttempts         int               // сколько раз задача выдается агентам, прежде чем считается невыполнимой
+	Now                 func() time.Time  // источник текущего времени
+	OnLease             func(models.Task) // вызывается после выдачи задачи агенту, до возврата ее из Next
+	// Stale сообщает, что задача больше не нужна (например, ее выражение отменено):
+	// такие задачи выбрасываются из очереди вместо выдачи агентам
+	Stale func(models.Task) bool
+}
+
+// lease — задача, выданная агенту
+type lease struct {
+	task    models.Task
+	agentID string
 }
 
+// Orchestrator хранит очередь готовых задач и выдает их агентам в аренду, отслеживая,
+// какие агенты живы и сколько задач у каждого в работе
 type Orchestrator struct {
-	tasks   chan models.Task
-	results chan models.Result
-	agents  map[string]AgentInfo
-	mu      sync.Mutex
-}
-
-func (o *Orchestrator) distributeTasks() {
-	for task := range o.tasks {
-		o.mu.Lock()
-		var bestAgent string
-		maxPower := 0
-		for id, agent := range o.agents {
-			if agent.ComputingPower > maxPower {
-				bestAgent = id
-				maxPower = agent.ComputingPower
-			}
+	tasks  chan models.Task
+	agents map[string]AgentInfo
+	leases map[string]lease // по ID задачи
+	// Задачи отмененных выражений, которые агенты еще вычисляют, по ID агента.
+	// Агент узнает о них из ответа на heartbeat
+	cancelled map[string][]string
+	mu        sync.Mutex
+	config    Config
+	// Отменяется в Close: задачи, ожидающие места в очереди в фоне, больше не ставятся
+	closed context.Context
+	close  context.CancelFunc
+}
+
+func New(config Config) *Orchestrator {
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if config.MaxMissedHeartbeats <= 0 {
+		config.MaxMissedHeartbeats = defaultMaxMissedHeartbeats
+	}
+	if config.LeaseTimeout <= 0 {
+		config.LeaseTimeout = defaultLeaseTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	o := &Orchestrator{
+		tasks:     make(chan models.Task, config.QueueSize),
+		agents:    make(map[string]AgentInfo),
+		leases:    make(map[string]lease),
+		cancelled: make(map[string][]string),
+		config:    config,
+	}
+	o.closed, o.close = context.WithCancel(context.Background())
+	return o
+}
+
+// Close прекращает фоновую постановку задач в очередь (см. Enqueue)
+func (o *Orchestrator) Close() {
+	o.close()
+}
+
+// Register добавляет агента или обновляет сведения о нем после перезапуска
+func (o *Orchestrator) Register(agent models.Agent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info := o.agents[agent.ID]
+	info.Agent = agent
+	info.LastSeen = o.config.Now()
+	info.Alive = true
+	o.agents[agent.ID] = info
+	log.Printf("Агент %s зарегистрирован, вычислительная мощность: %d", agent.ID, agent.ComputingPower)
+}
+
+// Heartbeat отмечает, что агент на связи, и сохраняет счетчики его воркеров, если они переданы.
+// Агент, ранее признанный отключенным, снова считается живым. Возвращает ID задач отмененных
+// выражений, которые агент может бросить: их результаты больше не нужны
+func (o *Orchestrator) Heartbeat(agentID string, workers []models.WorkerStats) ([]string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.touch(agentID); err != nil {
+		return nil, err
+	}
+	if workers != nil {
+		info := o.agents[agentID]
+		info.Workers = workers
+		o.agents[agentID] = info
+	}
+	cancelled := o.cancelled[agentID]
+	delete(o.cancelled, agentID)
+	return cancelled, nil
+}
+
+// touch обновляет время последней связи с агентом. Вызывается под mu
+func (o *Orchestrator) touch(agentID string) error {
+	info, ok := o.agents[agentID]
+	if !ok {
+		return ErrUnknownAgent
+	}
+	if !info.Alive {
+		log.Printf("Агент %s снова на связи", agentID)
+	}
+	info.LastSeen = o.config.Now()
+	info.Alive = true
+	o.agents[agentID] = info
+	return nil
+}
+
+// checkAgents помечает отключенными агентов, пропустивших MaxMissedHeartbeats heartbeat подряд.
+// Вызывается под mu
+func (o *Orchestrator) checkAgents() {
+	timeout := o.config.HeartbeatInterval * time.Duration(o.config.MaxMissedHeartbeats)
+	now := o.config.Now()
+	for id, info := range o.agents {
+		if info.Alive && now.Sub(info.LastSeen) > timeout {
+			info.Alive = false
+			o.agents[id] = info
+			log.Printf("Агент %s не отвечает с %s и считается отключенным", id, info.LastSeen.Format(time.RFC3339))
 		}
+	}
+}
+
+// Deregister удаляет агента, завершающего работу. Его незавершенные задачи сразу возвращаются
+// в очередь. Агент отдал их сам, поэтому эта выдача не считается попыткой
+func (o *Orchestrator) Deregister(agentID string) error {
+	o.mu.Lock()
+	if _, ok := o.agents[agentID]; !ok {
 		o.mu.Unlock()
+		return ErrUnknownAgent
+	}
+	delete(o.agents, agentID)
+	delete(o.cancelled, agentID)
+	var handedBack []models.Task
+	for id, l := range o.leases {
+		if l.agentID == agentID {
+			delete(o.leases, id)
+			l.task.LeaseDeadline = time.Time{}
+			l.task.Attempt--
+			handedBack = append(handedBack, l.task)
+		}
+	}
+	o.mu.Unlock()
+
+	log.Printf("Агент %s завершил работу, возвращено задач: %d", agentID, len(handedBack))
+	o.Enqueue(handedBack)
+	return nil
+}
+
+// HeartbeatInterval возвращает интервал, с которым агенты должны отправлять heartbeat
+func (o *Orchestrator) HeartbeatInterval() time.Duration {
+	return o.config.HeartbeatInterval
+}
+
+// Agents возвращает живых агентов, упорядоченных по ID
+func (o *Orchestrator) Agents() []AgentInfo {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.checkAgents()
+
+	list := make([]AgentInfo, 0, len(o.agents))
+	for _, info := range o.agents {
+		if info.Alive {
+			list = append(list, info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Leased возвращает число задач, выданных агентам и еще не выполненных
+func (o *Orchestrator) Leased() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.leases)
+}
+
+// Free возвращает число свободных мест в очереди задач
+func (o *Orchestrator) Free() int {
+	return cap(o.tasks) - len(o.tasks)
+}
+
+// TrySubmit ставит задачу в очередь, если в ней есть место
+func (o *Orchestrator) TrySubmit(task models.Task) bool {
+	select {
+	case o.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// Submit ставит задачу в очередь, дожидаясь свободного места
+func (o *Orchestrator) Submit(task models.Task) {
+	o.tasks <- task
+}
+
+// SubmitWait как Submit, но прекращает ожидание места при отмене ctx.
+// Возвращает false, если задача не поставлена в очередь
+func (o *Orchestrator) SubmitWait(ctx context.Context, task models.Task) bool {
+	select {
+	case o.tasks <- task:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Enqueue ставит задачи в очередь, не блокируясь. Задачи, для которых сейчас нет места,
+// ставятся в фоне по мере освобождения очереди, пока не вызван Close
+func (o *Orchestrator) Enqueue(tasks []models.Task) {
+	for i, task := range tasks {
+		if !o.TrySubmit(task) {
+			go o.enqueueWait(tasks[i:])
+			return
+		}
+		log.Printf("Задача с ID %s выражения %s добавлена в очередь", task.ID, task.ExpressionID)
+	}
+}
+
+func (o *Orchestrator) enqueueWait(tasks []models.Task) {
+	for i, task := range tasks {
+		if !o.SubmitWait(o.closed, task) {
+			log.Printf("Оркестратор остановлен, в очередь не поставлено задач: %d", len(tasks)-i)
+			return
+		}
+		log.Printf("Задача с ID %s выражения %s добавлена в очередь", task.ID, task.ExpressionID)
+	}
+}
+
+// Next выдает агенту следующую задачу из очереди в аренду на LeaseTimeout. Задача учитывается
+// в нагрузке агента, если он зарегистрирован; незарегистрированные агенты получают задачи без учета
+func (o *Orchestrator) Next(agentID string) (models.Task, bool) {
+	for {
+		select {
+		case task := <-o.tasks:
+			if o.stale(task) {
+				continue
+			}
+			return o.lease(task, agentID), true
+		default:
+			return models.Task{}, false
+		}
+	}
+}
+
+// NextWait как Next, но при пустой очереди ждет задачу до отмены ctx
+func (o *Orchestrator) NextWait(ctx context.Context, agentID string) (models.Task, bool) {
+	for {
+		select {
+		case task := <-o.tasks:
+			if o.stale(task) {
+				continue
+			}
+			return o.lease(task, agentID), true
+		case <-ctx.Done():
+			return models.Task{}, false
+		}
+	}
+}
 
-		if bestAgent != "" {
-			log.Printf("Задача с ID %s распределена агенту %s", task.ID, bestAgent)
+// stale сообщает, что задачу из очереди нужно выбросить
+func (o *Orchestrator) stale(task models.Task) bool {
+	if o.config.Stale == nil || !o.config.Stale(task) {
+		return false
+	}
+	log.Printf("Задача с ID %s выражения %s больше не нужна и убрана из очереди", task.ID, task.ExpressionID)
+	return true
+}
+
+// lease выдает задачу агенту в аренду
+func (o *Orchestrator) lease(task models.Task, agentID string) models.Task {
+	o.mu.Lock()
+	task.Attempt++
+	task.LeaseDeadline = o.config.Now().Add(o.config.LeaseTimeout)
+	task.LeaseToken = uuid.New().String()
+	o.leases[task.ID] = lease{task: task, agentID: agentID}
+	if o.touch(agentID) == nil {
+		info := o.agents[agentID]
+		info.Load++
+		o.agents[agentID] = info
+	}
+	o.mu.Unlock()
+
+	if o.config.OnLease != nil {
+		o.config.OnLease(task)
+	}
+	return task
+}
+
+// Release снимает аренду задачи по ключу token из Task.LeaseToken. Если задача сейчас не выдана
+// или выдана заново (аренда истекла), аренда не снимается и возвращается ErrLeaseMismatch.
+// Без ключа снимается только аренда агента, который не назвал свой ID: так работают агенты,
+// которые не знают о ключах аренды
+func (o *Orchestrator) Release(taskID, token string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	l, ok := o.leases[taskID]
+	if !ok {
+		return ErrLeaseMismatch
+	}
+	if token == "" && l.agentID != "" || token != "" && token != l.task.LeaseToken {
+		return ErrLeaseMismatch
+	}
+	o.release(taskID)
+	return nil
+}
+
+// release удаляет аренду задачи и уменьшает нагрузку агента. Вызывается под mu
+func (o *Orchestrator) release(taskID string) {
+	l, ok := o.leases[taskID]
+	if !ok {
+		return
+	}
+	delete(o.leases, taskID)
+	if info, ok := o.agents[l.agentID]; ok && info.Load > 0 {
+		info.Load--
+		o.agents[l.agentID] = info
+	}
+}
+
+// Cancel снимает аренду задач выражения, выданных агентам. Зарегистрированные агенты узнают
+// о снятых задачах из ответа на следующий heartbeat. Задачи выражения, ждущие в очереди,
+// выбрасываются при выдаче через Config.Stale. Возвращает число снятых аренд
+func (o *Orchestrator) Cancel(expressionID string) (abandoned int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for id, l := range o.leases {
+		if l.task.ExpressionID != expressionID {
+			continue
+		}
+		o.release(id)
+		abandoned++
+		if _, ok := o.agents[l.agentID]; ok {
+			o.cancelled[l.agentID] = append(o.cancelled[l.agentID], id)
+		}
+	}
+	return abandoned
+}
+
+// ExpireLeases возвращает в очередь задачи с истекшей арендой и задачи отключенных агентов.
+// Задачи, исчерпавшие MaxAttempts попыток, в очередь не возвращаются, а отдаются вызывающему
+func (o *Orchestrator) ExpireLeases() (exhausted []models.Task) {
+	o.mu.Lock()
+	o.checkAgents()
+	now := o.config.Now()
+	var retry []models.Task
+	for id, l := range o.leases {
+		info, registered := o.agents[l.agentID]
+		if now.Before(l.task.LeaseDeadline) && (!registered || info.Alive) {
+			continue
+		}
+		o.release(id)
+		log.Printf("Аренда задачи с ID %s (попытка %d) у агента %q истекла", id, l.task.Attempt, l.agentID)
+
+		task := l.task
+		task.LeaseDeadline = time.Time{}
+		if task.Attempt >= o.config.MaxAttempts {
+			exhausted = append(exhausted, task)
 		} else {
-			log.Printf("Нет доступных агентов для задачи с ID %s", task.ID)
+			retry = append(retry, task)
 		}
 	}
+	o.mu.Unlock()
+
+	// Возвращаем в очередь вне блокировки. При полной очереди задачи ставятся в фоне,
+	// чтобы проверка аренд не останавливалась
+	o.Enqueue(retry)
+	return exhausted
+}
+
+// RegisterAgentHandler регистрирует агента и сообщает ему интервал heartbeat
+func (o *Orchestrator) RegisterAgentHandler(w http.ResponseWriter, r *http.Request) {
+	var agent models.Agent
+	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	if agent.ID == "" || agent.ComputingPower <= 0 {
+		http.Error(w, "Нужны ID агента и положительная вычислительная мощность", http.StatusBadRequest)
+		return
+	}
+
+	o.Register(agent)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":                    agent.ID,
+		"heartbeat_interval_ms": o.HeartbeatInterval().Milliseconds(),
+	})
+}
+
+// HeartbeatHandler принимает heartbeat агента и возвращает ID задач, которые агент может бросить.
+// Незарегистрированный агент получает 404 и должен зарегистрироваться заново
+func (o *Orchestrator) HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	// Тело необязательно: в нем агент передает счетчики воркеров
+	var req struct {
+		Workers []models.WorkerStats `json:"workers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	cancelled, err := o.Heartbeat(mux.Vars(r)["id"], req.Workers)
+	if err != nil {
+		http.Error(w, "Агент не зарегистрирован", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cancelled_tasks": cancelled,
+	})
+}
+
+// DeregisterAgentHandler удаляет агента, завершающего работу
+func (o *Orchestrator) DeregisterAgentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := o.Deregister(mux.Vars(r)["id"]); err != nil {
+		http.Error(w, "Агент не зарегистрирован", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAgentsHandler возвращает живых агентов и их нагрузку
+func (o *Orchestrator) ListAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agents": o.Agents(),
+	})
 }
@@ -2,110 +2,426 @@ package orchestrator
 
 import (
 	"Second_sprint_final_task/pkg/models"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestDistributeTasks(t *testing.T) {
-	// Создаем Orchestrator
-	orch := &Orchestrator{
-		tasks:   make(chan models.Task, 10),
-		results: make(chan models.Result, 10),
-		agents:  make(map[string]AgentInfo),
+// Вспомогательная функция, создающая оркестратор с часами, которые двигает тест
+func newTestOrchestrator() (*Orchestrator, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	orch := New(Config{
+		QueueSize:           10,
+		HeartbeatInterval:   time.Second,
+		MaxMissedHeartbeats: 3,
+		LeaseTimeout:        10 * time.Second,
+		MaxAttempts:         2,
+		Now:                 func() time.Time { return now },
+	})
+	return orch, &now
+}
+
+func TestRegisterAndHeartbeat(t *testing.T) {
+	orch, now := newTestOrchestrator()
+
+	orch.Register(models.Agent{ID: "agent1", ComputingPower: 10, Version: "1.0.0"})
+	orch.Register(models.Agent{ID: "agent2", ComputingPower: 20})
+
+	if agents := orch.Agents(); len(agents) != 2 || agents[0].ID != "agent1" || agents[1].ComputingPower != 20 {
+		t.Fatalf("Ожидалось 2 живых агента, получено: %+v", agents)
 	}
 
-	// Добавляем агентов
-	orch.mu.Lock()
-	orch.agents["agent1"] = AgentInfo{ID: "agent1", ComputingPower: 10, LastSeen: time.Now()}
-	orch.agents["agent2"] = AgentInfo{ID: "agent2", ComputingPower: 20, LastSeen: time.Now()}
-	orch.mu.Unlock()
+	// agent1 продолжает отправлять heartbeat, agent2 пропускает больше трех
+	for i := 0; i < 4; i++ {
+		*now = now.Add(time.Second)
+		if _, err := orch.Heartbeat("agent1", nil); err != nil {
+			t.Fatalf("Неожиданная ошибка heartbeat: %v", err)
+		}
+	}
+	agents := orch.Agents()
+	if len(agents) != 1 || agents[0].ID != "agent1" {
+		t.Fatalf("Ожидался только agent1, получено: %+v", agents)
+	}
+	if orch.agents["agent2"].Alive {
+		t.Error("Ожидалось, что agent2 будет помечен отключенным")
+	}
 
-	// Запускаем распределение задач в отдельной горутине
-	go orch.distributeTasks()
+	// После heartbeat отключенный агент снова считается живым
+	orch.Heartbeat("agent2", nil)
+	if agents := orch.Agents(); len(agents) != 2 {
+		t.Errorf("Ожидалось 2 живых агента, получено: %d", len(agents))
+	}
 
-	// Добавляем задачи в канал
-	tasks := []models.Task{
-		{ID: "task1", Numbers: []float64{1, 2}, Operators: []string{"+"}},
-		{ID: "task2", Numbers: []float64{3, 4}, Operators: []string{"-"}},
+	if _, err := orch.Heartbeat("unknown", nil); err != ErrUnknownAgent {
+		t.Errorf("Ожидалась ошибка: %v, получено: %v", ErrUnknownAgent, err)
 	}
+}
+
+func TestNextAndRelease(t *testing.T) {
+	orch, _ := newTestOrchestrator()
+	orch.Register(models.Agent{ID: "agent1", ComputingPower: 1})
 
+	tasks := []models.Task{
+		{ID: "task1", Arg1: 1, Arg2: 2, Operation: "+"},
+		{ID: "task2", Arg1: 3, Arg2: 4, Operation: "-"},
+	}
 	for _, task := range tasks {
-		orch.tasks <- task
+		if !orch.TrySubmit(task) {
+			t.Fatalf("Задача %s не поместилась в очередь", task.ID)
+		}
+	}
+	if free := orch.Free(); free != 8 {
+		t.Errorf("Ожидалось 8 свободных мест в очереди, получено: %d", free)
+	}
+
+	first, ok := orch.Next("agent1")
+	if !ok || first.ID != "task1" {
+		t.Fatalf("Ожидалась задача task1, получено: %+v", first)
+	}
+	// Задача, выданная без ID агента, в нагрузке не учитывается
+	if second, ok := orch.Next(""); !ok || second.ID != "task2" {
+		t.Fatalf("Ожидалась задача task2, получено: %+v", second)
+	}
+	if _, ok := orch.Next("agent1"); ok {
+		t.Error("Ожидалась пустая очередь")
+	}
+
+	if load := orch.Agents()[0].Load; load != 1 {
+		t.Errorf("Ожидаемая нагрузка агента: 1, получено: %d", load)
+	}
+	orch.Release("task1", first.LeaseToken)
+	if load := orch.Agents()[0].Load; load != 0 {
+		t.Errorf("Ожидаемая нагрузка агента: 0, получено: %d", load)
+	}
+	if leased := orch.Leased(); leased != 1 {
+		t.Errorf("Ожидалась 1 выданная задача, получено: %d", leased)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	orch, _ := newTestOrchestrator()
+	orch.Register(models.Agent{ID: "agent1", ComputingPower: 1})
+	orch.TrySubmit(models.Task{ID: "task1"})
+	orch.TrySubmit(models.Task{ID: "task2"})
+	orch.TrySubmit(models.Task{ID: "task3"})
+	task1, _ := orch.Next("agent1")
+	task2, _ := orch.Next("agent1")
+	orch.Next("") // агент без ID, не знающий о ключах аренды
+	if task1.LeaseToken == "" || task1.LeaseToken == task2.LeaseToken {
+		t.Fatalf("Ожидались разные ключи аренды, получено: %q и %q", task1.LeaseToken, task2.LeaseToken)
+	}
+
+	tests := []struct {
+		taskID   string
+		token    string
+		expected error
+	}{
+		{"task1", task2.LeaseToken, ErrLeaseMismatch},
+		{"task1", "", ErrLeaseMismatch}, // задача выдана агенту с ID: без ключа не снимается
+		{"task1", task1.LeaseToken, nil},
+		{"task1", task1.LeaseToken, ErrLeaseMismatch}, // аренда уже снята
+		{"unknown", task1.LeaseToken, ErrLeaseMismatch},
+		{"task2", task2.LeaseToken, nil},
+		{"task3", "", nil},
+	}
+	for _, tt := range tests {
+		if err := orch.Release(tt.taskID, tt.token); err != tt.expected {
+			t.Errorf("Release(%s, %q): ожидалась ошибка %v, получено: %v", tt.taskID, tt.token, tt.expected, err)
+		}
+	}
+	if leased := orch.Leased(); leased != 0 {
+		t.Errorf("Ожидалось 0 выданных задач, получено: %d", leased)
+	}
+}
+
+func TestExpireLeases(t *testing.T) {
+	orch, now := newTestOrchestrator()
+	orch.Register(models.Agent{ID: "agent1", ComputingPower: 1})
+	orch.Register(models.Agent{ID: "agent2", ComputingPower: 1})
+	orch.TrySubmit(models.Task{ID: "task1"})
+	orch.TrySubmit(models.Task{ID: "task2"})
+
+	task1, _ := orch.Next("agent1")
+	task2, _ := orch.Next("agent2")
+	if task1.Attempt != 1 || !task1.LeaseDeadline.Equal(now.Add(10*time.Second)) {
+		t.Errorf("Ожидалась аренда на 10 секунд с попыткой 1, получено: %+v", task1)
+	}
+
+	// agent2 перестал отправлять heartbeat: его задача возвращается в очередь раньше срока аренды
+	for i := 0; i < 4; i++ {
+		*now = now.Add(time.Second)
+		orch.Heartbeat("agent1", nil)
+	}
+	if exhausted := orch.ExpireLeases(); len(exhausted) != 0 {
+		t.Errorf("Ожидалось, что попытки не исчерпаны, получено: %+v", exhausted)
+	}
+	retried, ok := orch.Next("agent1")
+	if !ok || retried.ID != task2.ID || retried.Attempt != 2 {
+		t.Fatalf("Ожидалась задача task2 с попыткой 2, получено: %+v", retried)
+	}
+	if load := orch.Agents()[0].Load; load != 2 {
+		t.Errorf("Ожидаемая нагрузка agent1: 2, получено: %d", load)
+	}
+
+	// Аренда истекла у обеих задач; task2 исчерпала попытки, task1 возвращается в очередь
+	*now = now.Add(time.Minute)
+	orch.Heartbeat("agent1", nil)
+	exhausted := orch.ExpireLeases()
+	if len(exhausted) != 1 || exhausted[0].ID != "task2" {
+		t.Errorf("Ожидалась исчерпавшая попытки задача task2, получено: %+v", exhausted)
+	}
+	if next, ok := orch.Next("agent1"); !ok || next.ID != "task1" || next.Attempt != 2 {
+		t.Errorf("Ожидалась задача task1 с попыткой 2, получено: %+v", next)
 	}
+	if _, ok := orch.Next("agent1"); ok {
+		t.Error("Ожидалась пустая очередь")
+	}
+}
 
-	// Даем время для обработки задач
-	time.Sleep(1 * time.Second)
+func TestDeregister(t *testing.T) {
+	orch, _ := newTestOrchestrator()
+	orch.Register(models.Agent{ID: "agent1", ComputingPower: 1})
+	orch.TrySubmit(models.Task{ID: "task1"})
+	orch.Next("agent1")
+	if leased := orch.Leased(); leased != 1 {
+		t.Fatalf("Ожидалась 1 выданная задача, получено: %d", leased)
+	}
 
-	// Проверяем, что задачи были распределены
-	orch.mu.Lock()
-	defer orch.mu.Unlock()
+	// Задачи агента, завершившего работу, сразу возвращаются в очередь
+	if err := orch.Deregister("agent1"); err != nil {
+		t.Fatalf("Ошибка при снятии агента с учета: %v", err)
+	}
+	if leased := orch.Leased(); leased != 0 {
+		t.Errorf("Ожидалось 0 выданных задач, получено: %d", leased)
+	}
+	// Агент отдал задачу сам: попытка не израсходована
+	if task, ok := orch.Next(""); !ok || task.ID != "task1" || task.Attempt != 1 {
+		t.Errorf("Ожидалась задача task1 с попыткой 1, получено: %+v", task)
+	}
+	if agents := orch.Agents(); len(agents) != 0 {
+		t.Errorf("Ожидалось 0 агентов, получено: %+v", agents)
+	}
+	if err := orch.Deregister("agent1"); err != ErrUnknownAgent {
+		t.Errorf("Ожидалась ошибка: %v, получено: %v", ErrUnknownAgent, err)
+	}
+}
 
-	// Проверяем, что агент с наибольшей вычислительной мощностью получил задачи
-	if len(orch.agents) != 2 {
-		t.Errorf("Ожидалось 2 агента, получено: %d", len(orch.agents))
+func TestNextWait(t *testing.T) {
+	orch, _ := newTestOrchestrator()
+
+	// Пустая очередь: ожидание прерывается отменой контекста
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if task, ok := orch.NextWait(ctx, ""); ok {
+		t.Fatalf("Ожидалось, что задачи не будет, получено: %+v", task)
 	}
 
-	// Проверяем, что задачи были распределены
-	if len(orch.tasks) != 0 {
-		t.Errorf("Ожидалось, что все задачи будут распределены, осталось: %d", len(orch.tasks))
+	// Задача, появившаяся во время ожидания, выдается сразу
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		orch.Submit(models.Task{ID: "task1"})
+	}()
+	task, ok := orch.NextWait(context.Background(), "")
+	if !ok || task.ID != "task1" || task.Attempt != 1 {
+		t.Errorf("Ожидалась задача task1 с попыткой 1, получено: %+v", task)
 	}
 }
 
-func TestDistributeTasksNoAgents(t *testing.T) {
-	// Создаем Orchestrator без агентов
-	orch := &Orchestrator{
-		tasks:   make(chan models.Task, 10),
-		results: make(chan models.Result, 10),
-		agents:  make(map[string]AgentInfo),
+func TestOnLease(t *testing.T) {
+	var leased []models.Task
+	orch := New(Config{QueueSize: 10, OnLease: func(task models.Task) { leased = append(leased, task) }})
+	orch.Submit(models.Task{ID: "task1"})
+	orch.Submit(models.Task{ID: "task2"})
+
+	orch.Next("agent1")
+	orch.NextWait(context.Background(), "agent1")
+	if len(leased) != 2 || leased[0].ID != "task1" || leased[1].Attempt != 1 {
+		t.Errorf("Ожидался вызов OnLease для task1 и task2, получено: %+v", leased)
 	}
+}
 
-	// Запускаем распределение задач в отдельной горутине
-	go orch.distributeTasks()
+func TestCancel(t *testing.T) {
+	cancelled := make(map[string]bool)
+	orch := New(Config{QueueSize: 10, Stale: func(task models.Task) bool { return cancelled[task.ExpressionID] }})
+	orch.Register(models.Agent{ID: "agent1", ComputingPower: 2})
+	for _, task := range []models.Task{
+		{ID: "a1", ExpressionID: "a"},
+		{ID: "b1", ExpressionID: "b"},
+		{ID: "a2", ExpressionID: "a"},
+		{ID: "a3", ExpressionID: "a"},
+		{ID: "b2", ExpressionID: "b"},
+	} {
+		orch.Submit(task)
+	}
+	orch.Next("agent1")
+	orch.Next("")
 
-	// Добавляем задачу в канал
-	task := models.Task{ID: "task1", Numbers: []float64{1, 2}, Operators: []string{"+"}}
-	orch.tasks <- task
+	// a1 выдана agent1, b1 — агенту без регистрации; в очереди остаются a2, a3 и b2
+	cancelled["a"] = true
+	if abandoned := orch.Cancel("a"); abandoned != 1 {
+		t.Errorf("Ожидалось снять 1 аренду, получено: %d", abandoned)
+	}
+	if orch.Leased() != 1 || orch.agents["agent1"].Load != 0 {
+		t.Errorf("Ожидалась одна аренда и нулевая нагрузка agent1, получено: %d, %+v", orch.Leased(), orch.agents["agent1"])
+	}
+	// Задачи отмененного выражения выбрасываются при выдаче, порядок остальных не меняется
+	if task, ok := orch.Next("agent1"); !ok || task.ID != "b2" {
+		t.Errorf("Ожидалась задача b2, получено: %+v", task)
+	}
+	if _, ok := orch.Next("agent1"); ok {
+		t.Error("Ожидалась пустая очередь")
+	}
 
-	// Даем время для обработки задачи
-	time.Sleep(1 * time.Second)
+	// agent1 узнает об отмене из первого heartbeat
+	for i, want := range [][]string{{"a1"}, nil} {
+		if cancelled, err := orch.Heartbeat("agent1", nil); err != nil || !reflect.DeepEqual(cancelled, want) {
+			t.Errorf("Heartbeat %d: ожидалось %v, получено: %v, ошибка: %v", i+1, want, cancelled, err)
+		}
+	}
+}
 
-	// Проверяем, что задача не была распределена
-	orch.mu.Lock()
-	defer orch.mu.Unlock()
+func TestNextSkipsStaleTasks(t *testing.T) {
+	orch := New(Config{QueueSize: 10, Stale: func(task models.Task) bool { return task.ExpressionID == "stale" }})
+	orch.Submit(models.Task{ID: "task1", ExpressionID: "stale"})
+	orch.Submit(models.Task{ID: "task2", ExpressionID: "expr"})
+	orch.Submit(models.Task{ID: "task3", ExpressionID: "stale"})
 
-	if len(orch.tasks) != 0 {
-		t.Errorf("Ожидалось, что задача будет удалена из канала, осталось: %d", len(orch.tasks))
+	if task, ok := orch.Next("agent1"); !ok || task.ID != "task2" {
+		t.Errorf("Ожидалась задача task2, получено: %+v", task)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if task, ok := orch.NextWait(ctx, "agent1"); ok {
+		t.Errorf("Ожидалось, что ненужная задача не будет выдана, получено: %+v", task)
 	}
 }
 
-func TestDistributeTasksSingleAgent(t *testing.T) {
-	// Создаем Orchestrator с одним агентом
-	orch := &Orchestrator{
-		tasks:   make(chan models.Task, 10),
-		results: make(chan models.Result, 10),
-		agents:  make(map[string]AgentInfo),
+func TestTrySubmitFullQueue(t *testing.T) {
+	orch := New(Config{QueueSize: 1})
+
+	if !orch.TrySubmit(models.Task{ID: "task1"}) {
+		t.Fatal("Ожидалось, что задача поместится в очередь")
 	}
+	if orch.TrySubmit(models.Task{ID: "task2"}) {
+		t.Error("Ожидалось, что переполненная очередь не примет задачу")
+	}
+
+	// Ожидание места прерывается отменой контекста
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if orch.SubmitWait(ctx, models.Task{ID: "task2"}) {
+		t.Error("Ожидалось, что задача не будет поставлена после отмены контекста")
+	}
+
+	// Освободившееся место занимается сразу
+	orch.Next("")
+	if !orch.SubmitWait(context.Background(), models.Task{ID: "task2"}) {
+		t.Error("Ожидалось, что задача поместится в освободившуюся очередь")
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	orch := New(Config{QueueSize: 1})
 
-	// Добавляем одного агента
-	orch.mu.Lock()
-	orch.agents["agent1"] = AgentInfo{ID: "agent1", ComputingPower: 10, LastSeen: time.Now()}
-	orch.mu.Unlock()
+	// Вторая задача не помещается в очередь и ставится в фоне, когда место освободится
+	orch.Enqueue([]models.Task{{ID: "task1"}, {ID: "task2"}})
+	first, _ := orch.Next("")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	second, ok := orch.NextWait(ctx, "")
+	if first.ID != "task1" || !ok || second.ID != "task2" {
+		t.Errorf("Ожидались задачи task1 и task2, получено: %+v, %+v", first, second)
+	}
+
+	// После Close фоновая постановка прекращается
+	orch.Enqueue([]models.Task{{ID: "task3"}, {ID: "task4"}})
+	orch.Close()
+	time.Sleep(20 * time.Millisecond)
+	orch.Next("")
+	time.Sleep(20 * time.Millisecond)
+	if task, ok := orch.Next(""); ok {
+		t.Errorf("Ожидалось, что после Close задачи не ставятся в очередь, получено: %+v", task)
+	}
+}
 
-	// Запускаем распределение задач в отдельной горутине
-	go orch.distributeTasks()
+func TestExpireLeasesFullQueue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	orch := New(Config{QueueSize: 1, LeaseTimeout: time.Second, Now: func() time.Time { return now }})
+	defer orch.Close()
+	orch.TrySubmit(models.Task{ID: "task1"})
+	orch.Next("agent1")
+	orch.TrySubmit(models.Task{ID: "task2"})
 
-	// Добавляем задачу в канал
-	task := models.Task{ID: "task1", Numbers: []float64{1, 2}, Operators: []string{"+"}}
-	orch.tasks <- task
+	// Очередь занята task2: возврат task1 не блокирует проверку аренд
+	now = now.Add(time.Minute)
+	done := make(chan struct{})
+	go func() {
+		orch.ExpireLeases()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ExpireLeases заблокировалась на полной очереди")
+	}
+	if task, _ := orch.Next("agent1"); task.ID != "task2" {
+		t.Errorf("Ожидалась задача task2, получено: %+v", task)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if task, ok := orch.NextWait(ctx, "agent1"); !ok || task.ID != "task1" || task.Attempt != 2 {
+		t.Errorf("Ожидалась возвращенная задача task1 с попыткой 2, получено: %+v", task)
+	}
+}
 
-	// Даем время для обработки задачи
-	time.Sleep(1 * time.Second)
+func TestAgentHandlers(t *testing.T) {
+	orch, _ := newTestOrchestrator()
+	r := mux.NewRouter()
+	r.HandleFunc("/internal/agents", orch.RegisterAgentHandler).Methods("POST")
+	r.HandleFunc("/internal/agents/{id}/heartbeat", orch.HeartbeatHandler).Methods("POST")
+	r.HandleFunc("/internal/agents", orch.ListAgentsHandler).Methods("GET")
 
-	// Проверяем, что задача была распределена
-	orch.mu.Lock()
-	defer orch.mu.Unlock()
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+	}{
+		{"POST", "/internal/agents", `{"id": "agent1", "computing_power": 2, "capabilities": ["operation"]}`, http.StatusCreated},
+		{"POST", "/internal/agents", `{"id": "agent2"}`, http.StatusBadRequest},
+		{"POST", "/internal/agents", `{`, http.StatusBadRequest},
+		{"POST", "/internal/agents/agent1/heartbeat", "", http.StatusOK},
+		{"POST", "/internal/agents/agent1/heartbeat", `{"workers": [{"id": 1, "processed": 5, "busy": true}, {"id": 2, "failed": 1}]}`, http.StatusOK},
+		{"POST", "/internal/agents/agent1/heartbeat", `{"workers": 1}`, http.StatusBadRequest},
+		{"POST", "/internal/agents/agent2/heartbeat", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if rr.Code != tt.expected {
+			t.Errorf("Ожидаемый статус код: %d, получено: %d для %s %s", tt.expected, rr.Code, tt.method, tt.path)
+		}
+	}
 
-	if len(orch.tasks) != 0 {
-		t.Errorf("Ожидалось, что задача будет распределена, осталось: %d", len(orch.tasks))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/internal/agents", nil))
+	var response struct {
+		Agents []AgentInfo `json:"agents"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Ошибка при декодировании ответа: %v", err)
+	}
+	if len(response.Agents) != 1 || response.Agents[0].ID != "agent1" || response.Agents[0].Capabilities[0] != "operation" {
+		t.Fatalf("Ожидался агент agent1, получено: %+v", response.Agents)
+	}
+	expected := []models.WorkerStats{{ID: 1, Processed: 5, Busy: true}, {ID: 2, Failed: 1}}
+	if !reflect.DeepEqual(response.Agents[0].Workers, expected) {
+		t.Errorf("Ожидаемые счетчики воркеров: %+v, получено: %+v", expected, response.Agents[0].Workers)
 	}
 }
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+var (
+	expressionsBucket = []byte("expressions")
+	createdBucket     = []byte("expressions_by_created") // индекс: ключ sortKey -> пусто
+	batchesBucket     = []byte("batches")
+)
+
+// BoltStore хранит выражения в файле BoltDB, поэтому они переживают перезапуск сервера
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{expressionsBucket, batchesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(createdBucket) != nil {
+			return nil
+		}
+		// Файл создан до появления индекса: строим его по сохраненным выражениям
+		index, err := tx.CreateBucket(createdBucket)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(expressionsBucket).ForEach(func(_, data []byte) error {
+			var expr models.Expression
+			if err := json.Unmarshal(data, &expr); err != nil {
+				return err
+			}
+			return index.Put(sortKey(expr.CreatedAt, expr.ID), nil)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Create(expr models.Expression) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(createdBucket)
+		if old, err := get(tx, expr.ID); err == nil {
+			if err := index.Delete(sortKey(old.CreatedAt, old.ID)); err != nil {
+				return err
+			}
+		}
+		if err := index.Put(sortKey(expr.CreatedAt, expr.ID), nil); err != nil {
+			return err
+		}
+		return put(tx, expr)
+	})
+}
+
+func (s *BoltStore) Get(id string) (models.Expression, error) {
+	var expr models.Expression
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		expr, err = get(tx, id)
+		return err
+	})
+	return expr, err
+}
+
+func (s *BoltStore) List() ([]models.Expression, error) {
+	list := []models.Expression{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(expressionsBucket).ForEach(func(_, data []byte) error {
+			var expr models.Expression
+			if err := json.Unmarshal(data, &expr); err != nil {
+				return err
+			}
+			list = append(list, expr)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *BoltStore) ListPage(query ListQuery) (Page, error) {
+	lo, hi, err := query.bounds()
+	if err != nil {
+		return Page{}, err
+	}
+	p := pager{query: query}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(createdBucket).Cursor()
+		var k []byte
+		switch {
+		case query.Desc && hi == nil:
+			k, _ = c.Last()
+		case query.Desc:
+			if k, _ = c.Seek(hi); k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		case lo == nil:
+			k, _ = c.First()
+		default:
+			k, _ = c.Seek(lo)
+		}
+
+		for ; k != nil; k = step(c, query.Desc) {
+			if (query.Desc && lo != nil && bytes.Compare(k, lo) < 0) || (!query.Desc && hi != nil && bytes.Compare(k, hi) >= 0) {
+				return nil
+			}
+			expr, err := get(tx, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			if !p.add(k, expr) {
+				return nil
+			}
+		}
+		return nil
+	})
+	return p.page, err
+}
+
+// step переходит к следующему ключу индекса в порядке выборки
+func step(c *bolt.Cursor, desc bool) []byte {
+	if desc {
+		k, _ := c.Prev()
+		return k
+	}
+	k, _ := c.Next()
+	return k
+}
+
+func (s *BoltStore) UpdateStatus(id, status string) error {
+	return s.updateActive(id, func(expr *models.Expression) {
+		expr.Status = status
+	})
+}
+
+func (s *BoltStore) SetResult(id string, result float64, decimal string, completedAt time.Time) error {
+	return s.updateActive(id, func(expr *models.Expression) {
+		expr.Status = models.StatusCompleted
+		expr.Result = result
+		expr.Decimal = decimal
+		expr.CompletedAt = completedAt
+	})
+}
+
+func (s *BoltStore) SetFailed(id, code, message string, completedAt time.Time) error {
+	return s.updateActive(id, func(expr *models.Expression) {
+		expr.Status = models.StatusFailed
+		expr.ErrorCode = code
+		expr.Error = message
+		expr.CompletedAt = completedAt
+	})
+}
+
+func (s *BoltStore) SetCancelled(id string, completedAt time.Time) error {
+	return s.updateActive(id, func(expr *models.Expression) {
+		expr.Status = models.StatusCancelled
+		expr.CompletedAt = completedAt
+	})
+}
+
+func (s *BoltStore) AddDelivery(id string, delivery models.Delivery) error {
+	return s.update(id, func(expr *models.Expression) {
+		expr.Deliveries = append(expr.Deliveries, delivery)
+	})
+}
+
+func (s *BoltStore) CreateBatch(batch models.Batch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(batchesBucket).Put([]byte(batch.ID), data)
+	})
+}
+
+func (s *BoltStore) GetBatch(id string) (models.Batch, error) {
+	var batch models.Batch
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(batchesBucket).Get([]byte(id))
+		if data == nil {
+			return ErrBatchNotFound
+		}
+		return json.Unmarshal(data, &batch)
+	})
+	return batch, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// update читает выражение, изменяет его и сохраняет в одной транзакции
+func (s *BoltStore) update(id string, change func(expr *models.Expression)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		expr, err := get(tx, id)
+		if err != nil {
+			return err
+		}
+		change(&expr)
+		return put(tx, expr)
+	})
+}
+
+// updateActive как update, но не меняет завершенное выражение и возвращает для него ErrFinished
+func (s *BoltStore) updateActive(id string, change func(expr *models.Expression)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		expr, err := get(tx, id)
+		if err != nil {
+			return err
+		}
+		if finished(expr.Status) {
+			return ErrFinished
+		}
+		change(&expr)
+		return put(tx, expr)
+	})
+}
+
+func get(tx *bolt.Tx, id string) (models.Expression, error) {
+	var expr models.Expression
+	data := tx.Bucket(expressionsBucket).Get([]byte(id))
+	if data == nil {
+		return expr, ErrNotFound
+	}
+	err := json.Unmarshal(data, &expr)
+	return expr, err
+}
+
+func put(tx *bolt.Tx, expr models.Expression) error {
+	data, err := json.Marshal(expr)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(expressionsBucket).Put([]byte(expr.ID), data)
+}
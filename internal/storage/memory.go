@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+	"time"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+// MemoryStore хранит выражения в памяти процесса
+type MemoryStore struct {
+	mu          sync.Mutex
+	expressions map[string]*models.Expression
+	order       [][]byte // ключи sortKey всех выражений по возрастанию
+	batches     map[string]models.Batch
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{expressions: make(map[string]*models.Expression), batches: make(map[string]models.Batch)}
+}
+
+func (s *MemoryStore) Create(expr models.Expression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.expressions[expr.ID]; ok {
+		i := s.search(sortKey(old.CreatedAt, old.ID))
+		s.order = slices.Delete(s.order, i, i+1)
+	}
+	s.expressions[expr.ID] = &expr
+	key := sortKey(expr.CreatedAt, expr.ID)
+	s.order = slices.Insert(s.order, s.search(key), key)
+	return nil
+}
+
+// search возвращает номер первого ключа в order, не меньшего key. Вызывается под mu
+func (s *MemoryStore) search(key []byte) int {
+	i, _ := slices.BinarySearchFunc(s.order, key, bytes.Compare)
+	return i
+}
+
+func (s *MemoryStore) Get(id string) (models.Expression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expr, ok := s.expressions[id]
+	if !ok {
+		return models.Expression{}, ErrNotFound
+	}
+	return *expr, nil
+}
+
+func (s *MemoryStore) List() ([]models.Expression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]models.Expression, 0, len(s.expressions))
+	for _, expr := range s.expressions {
+		list = append(list, *expr)
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) ListPage(query ListQuery) (Page, error) {
+	lo, hi, err := query.bounds()
+	if err != nil {
+		return Page{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := pager{query: query}
+	if query.Desc {
+		end := len(s.order)
+		if hi != nil {
+			end = s.search(hi)
+		}
+		for i := end - 1; i >= 0 && (lo == nil || bytes.Compare(s.order[i], lo) >= 0); i-- {
+			if !p.add(s.order[i], *s.expressions[string(s.order[i][8:])]) {
+				break
+			}
+		}
+		return p.page, nil
+	}
+	start := 0
+	if lo != nil {
+		start = s.search(lo)
+	}
+	for i := start; i < len(s.order) && (hi == nil || bytes.Compare(s.order[i], hi) < 0); i++ {
+		if !p.add(s.order[i], *s.expressions[string(s.order[i][8:])]) {
+			break
+		}
+	}
+	return p.page, nil
+}
+
+func (s *MemoryStore) UpdateStatus(id, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expr, err := s.active(id)
+	if err != nil {
+		return err
+	}
+	expr.Status = status
+	return nil
+}
+
+func (s *MemoryStore) SetResult(id string, result float64, decimal string, completedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expr, err := s.active(id)
+	if err != nil {
+		return err
+	}
+	expr.Status = models.StatusCompleted
+	expr.Result = result
+	expr.Decimal = decimal
+	expr.CompletedAt = completedAt
+	return nil
+}
+
+func (s *MemoryStore) SetFailed(id, code, message string, completedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expr, err := s.active(id)
+	if err != nil {
+		return err
+	}
+	expr.Status = models.StatusFailed
+	expr.ErrorCode = code
+	expr.Error = message
+	expr.CompletedAt = completedAt
+	return nil
+}
+
+func (s *MemoryStore) SetCancelled(id string, completedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expr, err := s.active(id)
+	if err != nil {
+		return err
+	}
+	expr.Status = models.StatusCancelled
+	expr.CompletedAt = completedAt
+	return nil
+}
+
+// active возвращает выражение, которое еще можно изменить. Вызывается под mu
+func (s *MemoryStore) active(id string) (*models.Expression, error) {
+	expr, ok := s.expressions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if finished(expr.Status) {
+		return nil, ErrFinished
+	}
+	return expr, nil
+}
+
+func (s *MemoryStore) AddDelivery(id string, delivery models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expr, ok := s.expressions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	// Копии, выданные Get, не должны увидеть новую попытку в общем массиве
+	expr.Deliveries = append(slices.Clip(expr.Deliveries), delivery)
+	return nil
+}
+
+func (s *MemoryStore) CreateBatch(batch models.Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[batch.ID] = batch
+	return nil
+}
+
+func (s *MemoryStore) GetBatch(id string) (models.Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch, ok := s.batches[id]
+	if !ok {
+		return models.Batch{}, ErrBatchNotFound
+	}
+	return batch, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+var (
+	ErrNotFound      = errors.New("выражение не найдено")
+	ErrBatchNotFound = errors.New("пакет не найден")
+	ErrInvalidCursor = errors.New("неверный курсор")
+	ErrFinished      = errors.New("выражение уже завершено")
+)
+
+// ExpressionStore — хранилище выражений. Методы возвращают копии,
+// поэтому изменения выражения нужно сохранять через UpdateStatus и SetResult
+type ExpressionStore interface {
+	Create(expr models.Expression) error
+	Get(id string) (models.Expression, error)
+	List() ([]models.Expression, error)
+	// ListPage возвращает страницу выражений, упорядоченных по времени создания, а при равном
+	// времени — по ID. Неверный курсор возвращается как ErrInvalidCursor
+	ListPage(query ListQuery) (Page, error)
+	// UpdateStatus, SetResult, SetFailed и SetCancelled не меняют завершенное выражение
+	// (completed, failed или cancelled) и возвращают для него ErrFinished
+	UpdateStatus(id, status string) error
+	// SetResult сохраняет результат и переводит выражение в статус completed.
+	// decimal — точный результат в режиме models.PrecisionDecimal
+	SetResult(id string, result float64, decimal string, completedAt time.Time) error
+	// SetFailed переводит выражение в статус failed с кодом и описанием ошибки
+	SetFailed(id, code, message string, completedAt time.Time) error
+	// SetCancelled переводит выражение в статус cancelled
+	SetCancelled(id string, completedAt time.Time) error
+	// AddDelivery добавляет к выражению попытку отправки на CallbackURL
+	AddDelivery(id string, delivery models.Delivery) error
+	CreateBatch(batch models.Batch) error
+	GetBatch(id string) (models.Batch, error)
+	Close() error
+}
+
+// Open создает хранилище по названию: "memory" (по умолчанию) или "bolt" — файл по пути path
+func Open(kind, path string) (ExpressionStore, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		return NewBoltStore(path)
+	}
+	return nil, errors.New("неизвестный тип хранилища: " + kind)
+}
+
+// finished сообщает, что выражение в этом статусе завершено и больше не меняется
+func finished(status string) bool {
+	return status == models.StatusCompleted || status == models.StatusFailed || status == models.StatusCancelled
+}
+
+// ListQuery — параметры выборки ListPage
+type ListQuery struct {
+	Status      string    // только выражения в этом статусе; пусто — в любом
+	CreatedFrom time.Time // созданные не раньше; нулевое время — без ограничения
+	CreatedTo   time.Time // созданные раньше; нулевое время — без ограничения
+	Desc        bool      // сначала новые
+	Cursor      string    // продолжить после выражения, на котором закончилась прошлая страница
+	Limit       int       // наибольшее число выражений на странице
+}
+
+// Page — страница выражений. NextCursor пуст, если страница последняя
+type Page struct {
+	Expressions []models.Expression
+	NextCursor  string
+}
+
+// sortKey — ключ, в порядке которого выдаются выражения: время создания в наносекундах
+// (big-endian, чтобы байты сравнивались как числа) и ID
+func sortKey(createdAt time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	if createdAt.After(time.Unix(0, 0)) {
+		binary.BigEndian.PutUint64(key, uint64(createdAt.UnixNano()))
+	}
+	return append(key, id...)
+}
+
+// bounds возвращает границы ключей выборки: от lo включительно до hi не включительно; nil — без границы
+func (q ListQuery) bounds() (lo, hi []byte, err error) {
+	if !q.CreatedFrom.IsZero() {
+		lo = sortKey(q.CreatedFrom, "")
+	}
+	if !q.CreatedTo.IsZero() {
+		hi = sortKey(q.CreatedTo, "")
+	}
+	if q.Cursor == "" {
+		return lo, hi, nil
+	}
+	after, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil || len(after) < 8 {
+		return nil, nil, ErrInvalidCursor
+	}
+	if q.Desc {
+		if hi == nil || bytes.Compare(after, hi) < 0 {
+			hi = after
+		}
+		return lo, hi, nil
+	}
+	// Следующий за курсором ключ: курсор, дополненный нулевым байтом
+	if next := append(after, 0); lo == nil || bytes.Compare(next, lo) > 0 {
+		lo = next
+	}
+	return lo, hi, nil
+}
+
+// pager собирает страницу из выражений, которые хранилище перебирает в порядке выборки
+type pager struct {
+	query ListQuery
+	page  Page
+	last  []byte
+}
+
+// add добавляет выражение с ключом key, если оно подходит под фильтр.
+// Возвращает false, когда страница заполнена и перебор можно прекратить
+func (p *pager) add(key []byte, expr models.Expression) bool {
+	if p.query.Status != "" && expr.Status != p.query.Status {
+		return true
+	}
+	if p.query.Limit > 0 && len(p.page.Expressions) == p.query.Limit {
+		// Есть хотя бы еще одно выражение: следующая страница начнется после последнего выданного
+		p.page.NextCursor = base64.RawURLEncoding.EncodeToString(p.last)
+		return false
+	}
+	p.page.Expressions = append(p.page.Expressions, expr)
+	p.last = key
+	return true
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"Second_sprint_final_task/pkg/models"
+)
+
+// testStore проверяет общее поведение всех реализаций ExpressionStore
+func testStore(t *testing.T, store ExpressionStore) {
+	expr := models.Expression{
+		ID:         "expr-1",
+		Expression: "price * 2",
+		Variables:  map[string]json.Number{"price": "10.5"},
+		Precision:  models.PrecisionDecimal,
+		Status:     "pending",
+	}
+	if err := store.Create(expr); err != nil {
+		t.Fatalf("Ошибка при создании выражения: %v", err)
+	}
+
+	got, err := store.Get("expr-1")
+	if err != nil {
+		t.Fatalf("Ошибка при получении выражения: %v", err)
+	}
+	if !reflect.DeepEqual(got, expr) {
+		t.Errorf("Ожидаемое выражение: %+v, получено: %+v", expr, got)
+	}
+
+	// Изменение копии не затрагивает хранилище
+	got.Status = "changed"
+	if stored, _ := store.Get("expr-1"); stored.Status != "pending" {
+		t.Errorf("Ожидаемый статус: pending, получено: %s", stored.Status)
+	}
+
+	if err := store.UpdateStatus("expr-1", "processing"); err != nil {
+		t.Fatalf("Ошибка при обновлении статуса: %v", err)
+	}
+	if got, _ := store.Get("expr-1"); got.Status != "processing" {
+		t.Errorf("Ожидаемый статус: processing, получено: %s", got.Status)
+	}
+
+	completedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := store.SetResult("expr-1", 21, "21", completedAt); err != nil {
+		t.Fatalf("Ошибка при сохранении результата: %v", err)
+	}
+	got, _ = store.Get("expr-1")
+	if got.Status != "completed" || got.Result != 21 || got.Decimal != "21" || !got.CompletedAt.Equal(completedAt) {
+		t.Errorf("Ожидалось завершенное выражение с результатом 21, получено: %+v", got)
+	}
+
+	if err := store.Create(models.Expression{ID: "expr-2", Expression: "1 + 2", Status: "pending"}); err != nil {
+		t.Fatalf("Ошибка при создании выражения: %v", err)
+	}
+	if err := store.SetFailed("expr-2", "DIVISION_BY_ZERO", "деление на ноль", completedAt); err != nil {
+		t.Fatalf("Ошибка при сохранении ошибки: %v", err)
+	}
+	if got, _ := store.Get("expr-2"); got.Status != "failed" || got.ErrorCode != "DIVISION_BY_ZERO" || got.Error != "деление на ноль" || !got.CompletedAt.Equal(completedAt) {
+		t.Errorf("Ожидалось выражение в статусе failed, получено: %+v", got)
+	}
+	before, _ := store.Get("expr-2")
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := store.AddDelivery("expr-2", models.Delivery{Attempt: attempt, StatusCode: 500}); err != nil {
+			t.Fatalf("Ошибка при сохранении попытки доставки: %v", err)
+		}
+	}
+	if got, _ := store.Get("expr-2"); len(got.Deliveries) != 2 || got.Deliveries[1].Attempt != 2 {
+		t.Errorf("Ожидались 2 попытки доставки, получено: %+v", got.Deliveries)
+	}
+	if len(before.Deliveries) != 0 {
+		t.Errorf("Ожидалось, что ранее полученная копия не изменится, получено: %+v", before.Deliveries)
+	}
+
+	if err := store.Create(models.Expression{ID: "expr-3", Expression: "3 * 3", Status: "processing"}); err != nil {
+		t.Fatalf("Ошибка при создании выражения: %v", err)
+	}
+	if err := store.SetCancelled("expr-3", completedAt); err != nil {
+		t.Fatalf("Ошибка при отмене выражения: %v", err)
+	}
+	if got, _ := store.Get("expr-3"); got.Status != "cancelled" || !got.CompletedAt.Equal(completedAt) {
+		t.Errorf("Ожидалось выражение в статусе cancelled, получено: %+v", got)
+	}
+
+	// Завершенное выражение не меняется: ни отмена, ни ошибка, пришедшие позже, его не перезаписывают
+	later := completedAt.Add(time.Minute)
+	finishedTests := []struct {
+		name   string
+		id     string
+		change func(id string) error
+	}{
+		{"Статус", "expr-1", func(id string) error { return store.UpdateStatus(id, "processing") }},
+		{"Результат", "expr-2", func(id string) error { return store.SetResult(id, 3, "", later) }},
+		{"Ошибка", "expr-3", func(id string) error { return store.SetFailed(id, "ATTEMPTS_EXHAUSTED", "", later) }},
+		{"Отмена", "expr-1", func(id string) error { return store.SetCancelled(id, later) }},
+	}
+	for _, tt := range finishedTests {
+		before, _ := store.Get(tt.id)
+		if err := tt.change(tt.id); !errors.Is(err, ErrFinished) {
+			t.Errorf("%s: ожидалась ошибка %v, получено: %v", tt.name, ErrFinished, err)
+		}
+		if after, _ := store.Get(tt.id); !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: ожидалось, что выражение не изменится, получено: %+v", tt.name, after)
+		}
+	}
+
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("Ошибка при получении списка: %v", err)
+	}
+	if len(list) != 3 {
+		t.Errorf("Ожидалось 3 выражения, получено: %d", len(list))
+	}
+
+	if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrNotFound, err)
+	}
+	if err := store.UpdateStatus("missing", "processing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrNotFound, err)
+	}
+	if err := store.SetResult("missing", 1, "", completedAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrNotFound, err)
+	}
+	if err := store.SetFailed("missing", "", "", completedAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrNotFound, err)
+	}
+	if err := store.SetCancelled("missing", completedAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrNotFound, err)
+	}
+	if err := store.AddDelivery("missing", models.Delivery{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrNotFound, err)
+	}
+
+	position := 4
+	batch := models.Batch{ID: "batch-1", Items: []models.BatchItem{
+		{ClientID: "a", ExpressionID: "expr-1"},
+		{ClientID: "b", ExpressionError: &models.ExpressionError{Error: "ожидалось число", ErrorCode: "INVALID_EXPRESSION", Position: &position}},
+	}}
+	if err := store.CreateBatch(batch); err != nil {
+		t.Fatalf("Ошибка при создании пакета: %v", err)
+	}
+	if got, err := store.GetBatch("batch-1"); err != nil || !reflect.DeepEqual(got, batch) {
+		t.Errorf("Ожидаемый пакет: %+v, получено: %+v (%v)", batch, got, err)
+	}
+	if _, err := store.GetBatch("missing"); !errors.Is(err, ErrBatchNotFound) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrBatchNotFound, err)
+	}
+}
+
+// testListPage проверяет выборку страниц во всех реализациях ExpressionStore
+func testListPage(t *testing.T, store ExpressionStore) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Создаем не по порядку; c и d созданы одновременно и упорядочиваются по ID
+	for _, expr := range []models.Expression{
+		{ID: "d", Status: models.StatusCompleted, CreatedAt: base.Add(2 * time.Minute)},
+		{ID: "a", Status: models.StatusCompleted, CreatedAt: base},
+		{ID: "e", Status: models.StatusPending, CreatedAt: base.Add(3 * time.Minute)},
+		{ID: "c", Status: models.StatusFailed, CreatedAt: base.Add(2 * time.Minute)},
+		{ID: "b", Status: models.StatusPending, CreatedAt: base.Add(time.Minute)},
+	} {
+		if err := store.Create(expr); err != nil {
+			t.Fatalf("Ошибка при создании выражения: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query ListQuery
+		want  []string // страницы выражений, пройденные по курсору
+	}{
+		{"Все по возрастанию", ListQuery{}, []string{"abcde"}},
+		{"Страницы по 2", ListQuery{Limit: 2}, []string{"ab", "cd", "e"}},
+		{"Страницы по 2 с конца", ListQuery{Limit: 2, Desc: true}, []string{"ed", "cb", "a"}},
+		{"По статусу", ListQuery{Status: models.StatusCompleted, Limit: 1}, []string{"a", "d"}},
+		{"По времени создания", ListQuery{CreatedFrom: base.Add(time.Minute), CreatedTo: base.Add(3 * time.Minute), Limit: 2}, []string{"bc", "d"}},
+		{"По времени создания с конца", ListQuery{CreatedFrom: base.Add(time.Minute), CreatedTo: base.Add(3 * time.Minute), Limit: 2, Desc: true}, []string{"dc", "b"}},
+		{"Пустая выборка", ListQuery{CreatedFrom: base.Add(time.Hour)}, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			query := tt.query
+			for {
+				page, err := store.ListPage(query)
+				if err != nil {
+					t.Fatalf("Ошибка при выборке: %v", err)
+				}
+				var ids strings.Builder
+				for _, expr := range page.Expressions {
+					ids.WriteString(expr.ID)
+				}
+				got = append(got, ids.String())
+				if page.NextCursor == "" || len(got) > len(tt.want) {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Ожидаемые страницы: %v, получено: %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := store.ListPage(ListQuery{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrInvalidCursor, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	testListPage(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBoltStore(filepath.Join(dir, "expressions.db"))
+	if err != nil {
+		t.Fatalf("Ошибка при открытии хранилища: %v", err)
+	}
+	defer store.Close()
+	testStore(t, store)
+
+	pages, err := NewBoltStore(filepath.Join(dir, "pages.db"))
+	if err != nil {
+		t.Fatalf("Ошибка при открытии хранилища: %v", err)
+	}
+	defer pages.Close()
+	testListPage(t, pages)
+}
+
+func TestBoltStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expressions.db")
+
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("Ошибка при открытии хранилища: %v", err)
+	}
+	store.Create(models.Expression{ID: "expr-1", Expression: "1 + 2", Status: "processing"})
+	store.Close()
+
+	// После повторного открытия выражение на месте
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("Ошибка при повторном открытии хранилища: %v", err)
+	}
+	defer store.Close()
+	got, err := store.Get("expr-1")
+	if err != nil || got.Status != "processing" {
+		t.Errorf("Ожидалось выражение в статусе processing, получено: %+v (%v)", got, err)
+	}
+}
+
+func TestBoltStoreBuildsIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expressions.db")
+
+	// Файл в прежнем формате: выражения без индекса по времени создания
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("Ошибка при открытии файла: %v", err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		bucket, _ := tx.CreateBucket(expressionsBucket)
+		bucket.Put([]byte("new"), []byte(`{"id": "new", "created_at": "2024-01-02T00:00:00Z"}`))
+		bucket.Put([]byte("old"), []byte(`{"id": "old", "created_at": "2024-01-01T00:00:00Z"}`))
+		return nil
+	})
+	db.Close()
+
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("Ошибка при открытии хранилища: %v", err)
+	}
+	defer store.Close()
+	page, err := store.ListPage(ListQuery{})
+	if err != nil || len(page.Expressions) != 2 || page.Expressions[0].ID != "old" {
+		t.Errorf("Ожидались выражения old и new, получено: %+v (%v)", page.Expressions, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	store, err := Open("", "")
+	if err != nil {
+		t.Fatalf("Ошибка при открытии хранилища по умолчанию: %v", err)
+	}
+	if _, ok := store.(*MemoryStore); !ok {
+		t.Errorf("Ожидалось хранилище в памяти, получено: %T", store)
+	}
+
+	if _, err := Open("redis", ""); err == nil {
+		t.Error("Ожидалась ошибка для неизвестного типа хранилища")
+	}
+}
@@ -0,0 +1,62 @@
+package calculation
+
+// Expr — разобранное выражение
+type Expr struct {
+	Source string
+	Root   Node
+}
+
+// Node — узел дерева выражения
+type Node interface {
+	// Pos возвращает смещение в байтах, с которого начинается узел в исходной строке
+	Pos() int
+}
+
+// NumberLit — числовой литерал. Text — запись из исходной строки, нужна для точного режима
+type NumberLit struct {
+	Value    float64
+	Text     string
+	Position int
+}
+
+// BinaryExpr — бинарная операция Left Op Right
+type BinaryExpr struct {
+	Op          string
+	Left, Right Node
+	OpPos       int
+}
+
+// UnaryExpr — унарная операция Op Operand
+type UnaryExpr struct {
+	Op      string
+	Operand Node
+	OpPos   int
+}
+
+// GroupExpr — выражение в скобках
+type GroupExpr struct {
+	Inner  Node
+	Lparen int
+	Rparen int
+}
+
+// VarRef — ссылка на именованную переменную
+type VarRef struct {
+	Name     string
+	Position int
+}
+
+// CallExpr — вызов функции Name(Args...)
+type CallExpr struct {
+	Name    string
+	Args    []Node
+	NamePos int
+	Rparen  int
+}
+
+func (n *NumberLit) Pos() int  { return n.Position }
+func (n *BinaryExpr) Pos() int { return n.Left.Pos() }
+func (n *UnaryExpr) Pos() int  { return n.OpPos }
+func (n *GroupExpr) Pos() int  { return n.Lparen }
+func (n *CallExpr) Pos() int   { return n.NamePos }
+func (n *VarRef) Pos() int     { return n.Position }
@@ -1,9 +1,10 @@
 package calculation
 
 import (
+	"math"
 	"os"
+	"sort"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -12,6 +13,9 @@ var (
 	subtractionTime    time.Duration
 	multiplicationTime time.Duration
 	divisionTime       time.Duration
+	powerTime          time.Duration
+	moduloTime         time.Duration
+	floorDivisionTime  time.Duration
 )
 
 func init() {
@@ -19,132 +23,140 @@ func init() {
 	subtractionTime = getEnvAsDuration("TIME_SUBTRACTION_MS", 200)
 	multiplicationTime = getEnvAsDuration("TIME_MULTIPLICATIONS_MS", 300)
 	divisionTime = getEnvAsDuration("TIME_DIVISIONS_MS", 400)
+	powerTime = getEnvAsDuration("TIME_POWER_MS", 500)
+	moduloTime = getEnvAsDuration("TIME_MODULO_MS", 400)
+	floorDivisionTime = getEnvAsDuration("TIME_FLOOR_DIVISION_MS", 400)
 }
 
+// Calc разбирает и вычисляет выражение
 func Calc(expression string) (float64, error) {
-	expression = strings.ReplaceAll(expression, " ", "")
-
-	// Проверка на корректность выражения
-	numbers := strings.FieldsFunc(expression, func(r rune) bool {
-		return r == '+' || r == '-' || r == '*' || r == '/' || r == '(' || r == ')'
-	})
-	operators := strings.FieldsFunc(expression, func(r rune) bool {
-		return (r >= '0' && r <= '9') || r == '.' || r == '(' || r == ')'
-	})
-
-	operators = removeEmptyStrings(operators)
-
-	if len(numbers) != len(operators)+1 {
-		return 0, ErrInvalidExpression
-	}
-
-	result, err := evaluateexpression(expression)
+	expr, err := Parse(expression)
 	if err != nil {
 		return 0, err
 	}
-	return result, nil
+	return Eval(expr)
 }
 
-// Вспомогательная функция для удаления пустых строк из слайса
-func removeEmptyStrings(slice []string) []string {
-	var result []string
-	for _, s := range slice {
-		if s != "" {
-			result = append(result, s)
-		}
-	}
-	return result
+// Eval вычисляет разобранное выражение без переменных
+func Eval(expr *Expr) (float64, error) {
+	return EvalWithVars(expr, nil)
 }
 
-func searchnumbers(expression string, index int) (float64, int) {
-	start := index
-	for index < len(expression) && (isDigit(expression[index]) || expression[index] == '.') {
-		index++
+// EvalWithVars вычисляет разобранное выражение, подставляя значения переменных из vars.
+// Если значения заданы не для всех переменных, возвращает *UnboundVariablesError
+func EvalWithVars(expr *Expr, vars map[string]float64) (float64, error) {
+	if expr == nil || expr.Root == nil {
+		return 0, ErrInvalidExpression
 	}
-	val, _ := strconv.ParseFloat(expression[start:index], 64)
-	return val, index
-}
-
-func precedence(op rune) int {
-	switch op {
-	case '+', '-':
-		return 1
-	case '*', '/':
-		return 2
+	if missing := MissingVariables(expr, vars); len(missing) > 0 {
+		return 0, &UnboundVariablesError{Names: missing}
 	}
-	return 0
-}
-
-func isDigit(char byte) bool {
-	return char >= '0' && char <= '9'
+	return evalNode(expr.Root, vars)
 }
 
-func isOperator(char byte) bool {
-	return char == '+' || char == '-' || char == '*' || char == '/'
-}
-
-func evaluateexpression(expression string) (float64, error) {
-	var ops []rune
-	var values []float64
-
-	for i := 0; i < len(expression); {
-		char := expression[i]
-		if isDigit(char) || char == '.' {
-			val, nextIndex := searchnumbers(expression, i)
-			values = append(values, val)
-			i = nextIndex
-		} else if char == '(' {
-			ops = append(ops, '(')
-			i++
-		} else if char == ')' {
-			for len(ops) > 0 && ops[len(ops)-1] != '(' {
-				var err error
-				values, err = attachOperator(ops[len(ops)-1], values)
-				if err != nil {
-					return 0, err
-				}
-				ops = ops[:len(ops)-1]
-			}
-			if len(ops) == 0 {
-				return 0, ErrInvalidParentheses
+// Variables возвращает отсортированный список имен переменных выражения без повторов
+func Variables(expr *Expr) []string {
+	seen := make(map[string]bool)
+	var names []string
+	var visit func(n Node)
+	visit = func(n Node) {
+		switch n := n.(type) {
+		case *VarRef:
+			if !seen[n.Name] {
+				seen[n.Name] = true
+				names = append(names, n.Name)
 			}
-			ops = ops[:len(ops)-1]
-			i++
-		} else if isOperator(char) {
-			currentOp := rune(char)
-			for len(ops) > 0 && precedence(currentOp) <= precedence(ops[len(ops)-1]) {
-				var err error
-				values, err = attachOperator(ops[len(ops)-1], values)
-				if err != nil {
-					return 0, err
-				}
-				ops = ops[:len(ops)-1]
+		case *GroupExpr:
+			visit(n.Inner)
+		case *UnaryExpr:
+			visit(n.Operand)
+		case *BinaryExpr:
+			visit(n.Left)
+			visit(n.Right)
+		case *CallExpr:
+			for _, arg := range n.Args {
+				visit(arg)
 			}
-			ops = append(ops, currentOp)
-			i++
-		} else {
-			return 0, ErrInvalidCalculation
 		}
 	}
+	if expr != nil && expr.Root != nil {
+		visit(expr.Root)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	for len(ops) > 0 {
-		op := ops[len(ops)-1]
-		if op == '(' {
-			return 0, ErrInvalidParentheses
+// MissingVariables возвращает переменные выражения, для которых в vars нет значений
+func MissingVariables(expr *Expr, vars map[string]float64) []string {
+	var missing []string
+	for _, name := range Variables(expr) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
 		}
-		var err error
-		values, err = attachOperator(op, values)
+	}
+	return missing
+}
+
+func evalNode(n Node, vars map[string]float64) (float64, error) {
+	switch n := n.(type) {
+	case *NumberLit:
+		return n.Value, nil
+	case *VarRef:
+		val, ok := vars[n.Name]
+		if !ok {
+			return 0, &UnboundVariablesError{Names: []string{n.Name}}
+		}
+		return val, nil
+	case *GroupExpr:
+		return evalNode(n.Inner, vars)
+	case *UnaryExpr:
+		val, err := evalNode(n.Operand, vars)
 		if err != nil {
 			return 0, err
 		}
-		ops = ops[:len(ops)-1]
+		switch n.Op {
+		case "+":
+			return val, nil
+		case "-":
+			return -val, nil
+		}
+		return 0, ErrInvalidOperand
+	case *BinaryExpr:
+		a, err := evalNode(n.Left, vars)
+		if err != nil {
+			return 0, err
+		}
+		b, err := evalNode(n.Right, vars)
+		if err != nil {
+			return 0, err
+		}
+		return Apply(n.Op, a, b)
+	case *CallExpr:
+		args := make([]float64, len(n.Args))
+		for i, arg := range n.Args {
+			val, err := evalNode(arg, vars)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = val
+		}
+		return Call(n.Name, args)
 	}
+	return 0, ErrInvalidCalculation
+}
 
-	if len(values) != 1 {
-		return 0, ErrInvalidValuesCount
+func precedence(op string) int {
+	switch op {
+	case "+", "-":
+		return 1
+	case "*", "/", "%", "//":
+		return 2
+	case "^":
+		return 3
 	}
-	return values[0], nil
+	return 0
 }
+
 func getEnvAsDuration(key string, defaultValue int) time.Duration {
 	value := os.Getenv(key)
 	if value == "" {
@@ -157,7 +169,49 @@ func getEnvAsDuration(key string, defaultValue int) time.Duration {
 	return time.Duration(intValue) * time.Millisecond
 }
 
-func attachOperator(op rune, values []float64) ([]float64, error) {
+func getEnvAsInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return intValue
+}
+
+// operationTime возвращает имитируемое время выполнения оператора
+func operationTime(op string) (time.Duration, bool) {
+	switch op {
+	case "+":
+		return additionTime, true
+	case "-":
+		return subtractionTime, true
+	case "*":
+		return multiplicationTime, true
+	case "/":
+		return divisionTime, true
+	case "//":
+		return floorDivisionTime, true
+	case "%":
+		return moduloTime, true
+	case "^":
+		return powerTime, true
+	}
+	return 0, false
+}
+
+// Apply выполняет одну бинарную операцию над двумя числами
+func Apply(op string, a, b float64) (float64, error) {
+	values, err := attachOperator(op, []float64{a, b})
+	if err != nil {
+		return 0, err
+	}
+	return values[0], nil
+}
+
+func attachOperator(op string, values []float64) ([]float64, error) {
 	if len(values) < 2 {
 		return values, ErrInvalidValuesCount
 	}
@@ -166,27 +220,50 @@ func attachOperator(op rune, values []float64) ([]float64, error) {
 	values = values[:len(values)-2]
 
 	// Добавляем задержку в зависимости от операции
+	var result float64
 	switch op {
-	case '+':
+	case "+":
 		time.Sleep(additionTime)
-		result := b + a
-		return append(values, result), nil
-	case '-':
+		result = b + a
+	case "-":
 		time.Sleep(subtractionTime)
-		result := b - a
-		return append(values, result), nil
-	case '*':
+		result = b - a
+	case "*":
 		time.Sleep(multiplicationTime)
-		result := b * a
-		return append(values, result), nil
-	case '/':
+		result = b * a
+	case "/":
 		time.Sleep(divisionTime)
 		if a == 0 {
 			return values, ErrInvalidZero
 		}
-		result := b / a
-		return append(values, result), nil
+		result = b / a
+	case "//":
+		time.Sleep(floorDivisionTime)
+		if a == 0 {
+			return values, ErrInvalidZero
+		}
+		result = math.Floor(b / a)
+	case "%":
+		time.Sleep(moduloTime)
+		if a == 0 {
+			return values, ErrInvalidZero
+		}
+		result = math.Mod(b, a)
+	case "^":
+		time.Sleep(powerTime)
+		// 0 ^ -1 или (-8) ^ 0.5 не имеют конечного вещественного значения
+		if (b == 0 && a < 0) || (b < 0 && a != math.Trunc(a)) {
+			return values, ErrInvalidCalculation
+		}
+		result = math.Pow(b, a)
 	default:
 		return values, ErrInvalidOperand
 	}
+
+	// 10^300 * 10^300 не помещается в float64: без проверки получили бы +Inf,
+	// который нельзя отдать клиенту в JSON
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return values, ErrOverflow
+	}
+	return append(values, result), nil
 }
@@ -1,6 +1,8 @@
 package calculation
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -41,7 +43,7 @@ func TestCalc(t *testing.T) {
 	}
 }
 
-func TestEvaluateExpression(t *testing.T) {
+func TestEval(t *testing.T) {
 	tests := []struct {
 		name        string
 		expression  string
@@ -53,15 +55,29 @@ func TestEvaluateExpression(t *testing.T) {
 		{"Simple multiplication", "2*3", 6, false},
 		{"Simple division", "6/2", 3, false},
 		{"Complex expression", "2+3*4", 14, false},
+		{"Left associativity", "8-3-2", 3, false},
 		{"Division by zero", "1/0", 0, true},
-		{"Invalid expression", "1+", 0, true},
 		{"Parentheses", "(1+2)*3", 9, false},
-		{"Invalid parentheses", "(1+2", 0, true},
+		{"Nested parentheses", "((2+3)*(4+5))", 45, false},
+		{"Power", "2^10", 1024, false},
+		{"Power is right associative", "2^3^2", 512, false},
+		{"Power binds tighter than multiplication", "3*2^2", 12, false},
+		{"Modulo", "7%3", 1, false},
+		{"Modulo precedence", "1+7%3*2", 3, false},
+		{"Floor division", "7//2", 3, false},
+		{"Floor division of negative", "-7//2", -4, false},
+		{"Modulo by zero", "5%0", 0, true},
+		{"Floor division by zero", "5//0", 0, true},
+		{"Power with infinite result", "0^-1", 0, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := evaluateexpression(tt.expression)
+			expr, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка разбора: %s: %v", tt.expression, err)
+			}
+			result, err := Eval(expr)
 			if tt.expectError {
 				if err == nil {
 					t.Errorf("Ожидалась ошибка для выражения: %s", tt.expression)
@@ -78,20 +94,93 @@ func TestEvaluateExpression(t *testing.T) {
 	}
 }
 
+func TestEvalUnary(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"-3 + 5", 2},
+		{"2 * -4", -8},
+		{"-(1+2)", -3},
+		{"+7", 7},
+		{"--2", 2},
+		{"-2^2", -4},
+		{"2^-1", 0.5},
+		{"(-2)^2", 4},
+		{"-2 * -3", 6},
+		{"6 / -2 - 1", -4},
+		{"1 - -1", 2},
+		{"-(2 + 3) * 2", -10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Calc(tt.expression)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка для выражения: %s: %v", tt.expression, err)
+			}
+			if result != tt.expected {
+				t.Errorf("Ожидаемый результат: %f, получено: %f для выражения: %s", tt.expected, result, tt.expression)
+			}
+		})
+	}
+
+	if _, err := Eval(&Expr{}); err == nil {
+		t.Error("Ожидалась ошибка для пустого выражения")
+	}
+}
+
+func TestEvalWithVars(t *testing.T) {
+	expr, err := Parse("price * qty * (1 - discount)")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка разбора: %v", err)
+	}
+
+	if names := Variables(expr); !equalSlices(names, []string{"discount", "price", "qty"}) {
+		t.Errorf("Ожидаемые переменные: [discount price qty], получено: %v", names)
+	}
+
+	result, err := EvalWithVars(expr, map[string]float64{"price": 10, "qty": 3, "discount": 0.5})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if result != 15 {
+		t.Errorf("Ожидаемый результат: 15, получено: %f", result)
+	}
+
+	// Без значений для части переменных ошибка перечисляет все недостающие имена
+	_, err = EvalWithVars(expr, map[string]float64{"price": 10})
+	var uerr *UnboundVariablesError
+	if !errors.As(err, &uerr) || !errors.Is(err, ErrUnboundVariable) {
+		t.Fatalf("Ожидалась UnboundVariablesError, получено: %v", err)
+	}
+	if !equalSlices(uerr.Names, []string{"discount", "qty"}) {
+		t.Errorf("Ожидаемые недостающие переменные: [discount qty], получено: %v", uerr.Names)
+	}
+
+	// Eval не подставляет переменные
+	if _, err := Eval(expr); !errors.Is(err, ErrUnboundVariable) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrUnboundVariable, err)
+	}
+}
+
 func TestAttachOperator(t *testing.T) {
 	tests := []struct {
 		name        string
-		op          rune
+		op          string
 		values      []float64
 		expected    []float64
 		expectError bool
 	}{
-		{"Addition", '+', []float64{2, 3}, []float64{5}, false},
-		{"Subtraction", '-', []float64{5, 3}, []float64{2}, false},
-		{"Multiplication", '*', []float64{2, 3}, []float64{6}, false},
-		{"Division", '/', []float64{6, 2}, []float64{3}, false},
-		{"Division by zero", '/', []float64{1, 0}, []float64{}, true},
-		{"Invalid operand", 'x', []float64{1, 2}, []float64{}, true},
+		{"Addition", "+", []float64{2, 3}, []float64{5}, false},
+		{"Subtraction", "-", []float64{5, 3}, []float64{2}, false},
+		{"Multiplication", "*", []float64{2, 3}, []float64{6}, false},
+		{"Division", "/", []float64{6, 2}, []float64{3}, false},
+		{"Division by zero", "/", []float64{1, 0}, []float64{}, true},
+		{"Floor division", "//", []float64{7, 2}, []float64{3}, false},
+		{"Modulo", "%", []float64{7, 4}, []float64{3}, false},
+		{"Power", "^", []float64{3, 2}, []float64{9}, false},
+		{"Invalid operand", "x", []float64{1, 2}, []float64{}, true},
 	}
 
 	for _, tt := range tests {
@@ -99,87 +188,132 @@ func TestAttachOperator(t *testing.T) {
 			result, err := attachOperator(tt.op, tt.values)
 			if tt.expectError {
 				if err == nil {
-					t.Errorf("Ожидалась ошибка для операции: %c", tt.op)
+					t.Errorf("Ожидалась ошибка для операции: %s", tt.op)
 				}
 			} else {
 				if err != nil {
-					t.Errorf("Неожиданная ошибка для операции: %c: %v", tt.op, err)
+					t.Errorf("Неожиданная ошибка для операции: %s: %v", tt.op, err)
 				}
 				if len(result) != len(tt.expected) || (len(result) > 0 && result[0] != tt.expected[0]) {
-					t.Errorf("Ожидаемый результат: %v, получено: %v для операции: %c", tt.expected, result, tt.op)
+					t.Errorf("Ожидаемый результат: %v, получено: %v для операции: %s", tt.expected, result, tt.op)
 				}
 			}
 		})
 	}
 }
 
-func TestSearchNumbers(t *testing.T) {
+func TestOverflow(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    string
-		start    int
-		expected float64
-		next     int
+		expression string
+		expected   error
 	}{
-		{"Single digit", "1+2", 0, 1, 1},
-		{"Multiple digits", "123+456", 0, 123, 3},
-		{"Decimal number", "1.23+4.56", 0, 1.23, 4},
+		{"10 ^ 300 * 10 ^ 300", ErrOverflow},
+		{"10 ^ 308 + 10 ^ 308", ErrOverflow},
+		{"-10 ^ 308 - 10 ^ 308", ErrOverflow},
+		{"10 ^ 300 / 10 ^ -300", ErrOverflow},
+		{"10 ^ 400", ErrOverflow},
+		{"0 ^ -1", ErrInvalidCalculation},
+		{"(-8) ^ 0.5", ErrInvalidCalculation},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result, next := searchnumbers(tt.input, tt.start)
-			if result != tt.expected || next != tt.next {
-				t.Errorf("Ожидаемый результат: %f, %d; получено: %f, %d для ввода: %s", tt.expected, tt.next, result, next, tt.input)
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Calc(tt.expression)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Ожидаемая ошибка: %v, получено: %v (результат %f)", tt.expected, err, result)
 			}
 		})
 	}
+	if code := ErrorCode(ErrOverflow); code != CodeOverflow {
+		t.Errorf("Ожидаемый код: %s, получено: %s", CodeOverflow, code)
+	}
 }
 
 func TestPrecedence(t *testing.T) {
 	tests := []struct {
 		name     string
-		op       rune
+		op       string
 		expected int
 	}{
-		{"Addition", '+', 1},
-		{"Subtraction", '-', 1},
-		{"Multiplication", '*', 2},
-		{"Division", '/', 2},
-		{"Unknown operator", 'x', 0},
+		{"Addition", "+", 1},
+		{"Subtraction", "-", 1},
+		{"Multiplication", "*", 2},
+		{"Division", "/", 2},
+		{"Modulo", "%", 2},
+		{"Floor division", "//", 2},
+		{"Power", "^", 3},
+		{"Unknown operator", "x", 0},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			result := precedence(tt.op)
 			if result != tt.expected {
-				t.Errorf("Ожидаемый приоритет: %d, получено: %d для оператора: %c", tt.expected, result, tt.op)
+				t.Errorf("Ожидаемый приоритет: %d, получено: %d для оператора: %s", tt.expected, result, tt.op)
 			}
 		})
 	}
 }
 
-func TestRemoveEmptyStrings(t *testing.T) {
+func TestApply(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    []string
-		expected []string
+		name        string
+		op          string
+		a, b        float64
+		expected    float64
+		expectError bool
 	}{
-		{"No empty strings", []string{"a", "b", "c"}, []string{"a", "b", "c"}},
-		{"With empty strings", []string{"a", "", "b", "", "c"}, []string{"a", "b", "c"}},
-		{"All empty strings", []string{"", "", ""}, []string{}},
+		{"Addition", "+", 2, 3, 5, false},
+		{"Subtraction", "-", 5, 3, 2, false},
+		{"Multiplication", "*", 2, 3, 6, false},
+		{"Division", "/", 6, 2, 3, false},
+		{"Division by zero", "/", 1, 0, 0, true},
+		{"Floor division", "//", -7, 2, -4, false},
+		{"Modulo by zero", "%", 1, 0, 0, true},
+		{"Overflow", "*", 1e300, 1e300, 0, true},
+		{"Invalid operand", "x", 1, 2, 0, true},
+		{"Empty operand", "", 1, 2, 0, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := removeEmptyStrings(tt.input)
-			if !equalSlices(result, tt.expected) {
-				t.Errorf("Ожидаемый результат: %v, получено: %v для ввода: %v", tt.expected, result, tt.input)
+			result, err := Apply(tt.op, tt.a, tt.b)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Ожидалась ошибка для операции: %s", tt.op)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Неожиданная ошибка для операции: %s: %v", tt.op, err)
+				}
+				if result != tt.expected {
+					t.Errorf("Ожидаемый результат: %f, получено: %f для операции: %s", tt.expected, result, tt.op)
+				}
 			}
 		})
 	}
 }
 
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{ErrInvalidZero, CodeDivisionByZero},
+		{fmt.Errorf("ошибка при вычислении 1 / 0: %w", ErrInvalidZero), CodeDivisionByZero},
+		{ErrNegativeSqrt, CodeNegativeSqrt},
+		{&SyntaxError{Pos: 3, Msg: "ожидалось число", Err: ErrInvalidExpression}, CodeInvalidExpression},
+		{&UnboundVariablesError{Names: []string{"x"}}, CodeUnboundVariable},
+		{errors.New("что-то другое"), CodeUnknown},
+	}
+
+	for _, tt := range tests {
+		if code := ErrorCode(tt.err); code != tt.expected {
+			t.Errorf("Ожидаемый код: %s, получено: %s для ошибки: %v", tt.expected, code, tt.err)
+		}
+	}
+}
+
 // Вспомогательная функция для сравнения слайсов
 func equalSlices[T comparable](a, b []T) bool {
 	if len(a) != len(b) {
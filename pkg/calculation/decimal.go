@@ -0,0 +1,327 @@
+package calculation
+
+import (
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// DecimalContext — параметры точного десятичного режима.
+// Промежуточные результаты хранятся точно (big.Rat); Scale и Rounding применяются
+// только при форматировании итогового результата, Precision — при вычислении корня
+type DecimalContext struct {
+	Scale     int              // число знаков после запятой в итоговом результате
+	Rounding  big.RoundingMode // режим округления итогового результата
+	Precision uint             // точность big.Float в битах для sqrt
+}
+
+// Decimal — параметры десятичного режима, читаются из переменных окружения
+// DECIMAL_SCALE, DECIMAL_ROUNDING и DECIMAL_PRECISION
+var Decimal = DecimalContext{
+	Scale:     20,
+	Rounding:  big.ToNearestEven,
+	Precision: 256,
+}
+
+func init() {
+	Decimal = decimalFromEnv(Decimal)
+}
+
+// decimalFromEnv читает параметры десятичного режима из переменных окружения. Незаданные
+// и недопустимые значения (отрицательный масштаб, неположительная точность) берутся из defaults
+func decimalFromEnv(defaults DecimalContext) DecimalContext {
+	ctx := defaults
+	if scale := getEnvAsInt("DECIMAL_SCALE", ctx.Scale); scale >= 0 {
+		ctx.Scale = scale
+	}
+	if precision := getEnvAsInt("DECIMAL_PRECISION", int(ctx.Precision)); precision > 0 {
+		ctx.Precision = uint(precision)
+	}
+	if mode, ok := ParseRoundingMode(os.Getenv("DECIMAL_ROUNDING")); ok {
+		ctx.Rounding = mode
+	}
+	return ctx
+}
+
+// maxExactExponent ограничивает целый показатель, при котором степень вычисляется точно
+const maxExactExponent = 10000
+
+// maxExactPowerBits ограничивает размер точной степени: числитель и знаменатель результата
+// не длиннее стольких бит (около 300 тысяч десятичных цифр). Большие степени вычислялись бы
+// и форматировались дольше аренды задачи
+const maxExactPowerBits = 1 << 20
+
+// ParseRoundingMode разбирает название режима округления big.RoundingMode, например ToNearestEven
+func ParseRoundingMode(name string) (big.RoundingMode, bool) {
+	for mode := big.ToNearestEven; mode <= big.ToPositiveInf; mode++ {
+		if strings.EqualFold(mode.String(), name) {
+			return mode, true
+		}
+	}
+	return 0, false
+}
+
+// ParseDecimal разбирает точное значение: десятичную запись ("0.1", "1e-3") или дробь ("1/3")
+func ParseDecimal(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, ErrInvalidExpression
+	}
+	return r, nil
+}
+
+// FormatDecimal форматирует значение в десятичную запись не более чем с ctx.Scale
+// знаками после запятой, округляя по ctx.Rounding. Незначащие нули отбрасываются.
+// Отрицательный масштаб считается нулевым
+func FormatDecimal(r *big.Rat, ctx DecimalContext) string {
+	ctx.Scale = max(ctx.Scale, 0)
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(ctx.Scale)), nil)
+	num := new(big.Int).Mul(r.Num(), scale)
+	quo, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+
+	if rem.Sign() != 0 && roundAway(quo, rem, r.Denom(), r.Sign(), ctx.Rounding) {
+		quo.Add(quo, big.NewInt(int64(r.Sign())))
+	}
+
+	digits := new(big.Int).Abs(quo).String()
+	if len(digits) <= ctx.Scale {
+		digits = strings.Repeat("0", ctx.Scale-len(digits)+1) + digits
+	}
+	intPart, fracPart := digits[:len(digits)-ctx.Scale], strings.TrimRight(digits[len(digits)-ctx.Scale:], "0")
+
+	result := intPart
+	if fracPart != "" {
+		result += "." + fracPart
+	}
+	if quo.Sign() < 0 {
+		result = "-" + result
+	}
+	return result
+}
+
+// roundAway решает, нужно ли увеличить по модулю усеченное частное quo при ненулевом остатке rem
+func roundAway(quo, rem, denom *big.Int, sign int, mode big.RoundingMode) bool {
+	switch mode {
+	case big.ToZero:
+		return false
+	case big.AwayFromZero:
+		return true
+	case big.ToNegativeInf:
+		return sign < 0
+	case big.ToPositiveInf:
+		return sign > 0
+	}
+
+	// Округление к ближайшему: сравниваем удвоенный остаток со знаменателем
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	switch twice.Cmp(denom) {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+	if mode == big.ToNearestAway {
+		return true
+	}
+	return quo.Bit(0) == 1
+}
+
+// ApplyDecimal выполняет одну бинарную операцию над точными значениями
+func ApplyDecimal(op string, a, b *big.Rat) (*big.Rat, error) {
+	duration, ok := operationTime(op)
+	if !ok {
+		return nil, ErrInvalidOperand
+	}
+	time.Sleep(duration)
+
+	switch op {
+	case "+":
+		return new(big.Rat).Add(a, b), nil
+	case "-":
+		return new(big.Rat).Sub(a, b), nil
+	case "*":
+		return new(big.Rat).Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, ErrInvalidZero
+		}
+		return new(big.Rat).Quo(a, b), nil
+	case "//":
+		if b.Sign() == 0 {
+			return nil, ErrInvalidZero
+		}
+		return floorRat(new(big.Rat).Quo(a, b)), nil
+	case "%":
+		if b.Sign() == 0 {
+			return nil, ErrInvalidZero
+		}
+		// Знак остатка совпадает со знаком делимого, как у math.Mod
+		quo := new(big.Rat).Quo(a, b)
+		trunc := new(big.Rat).SetInt(new(big.Int).Quo(quo.Num(), quo.Denom()))
+		return new(big.Rat).Sub(a, trunc.Mul(trunc, b)), nil
+	default: // "^"
+		return powRat(a, b)
+	}
+}
+
+// powRat возводит в степень точно, если показатель целый, иначе — через float64
+func powRat(base, exponent *big.Rat) (*big.Rat, error) {
+	if !exponent.IsInt() || exponent.Num().CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+		b, _ := base.Float64()
+		e, _ := exponent.Float64()
+		result := math.Pow(b, e)
+		if math.IsInf(result, 0) || math.IsNaN(result) {
+			return nil, ErrInvalidCalculation
+		}
+		return new(big.Rat).SetFloat64(result), nil
+	}
+
+	n := exponent.Num().Int64()
+	if n < 0 && base.Sign() == 0 {
+		return nil, ErrInvalidCalculation
+	}
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if int64(base.Num().BitLen())*abs > maxExactPowerBits || int64(base.Denom().BitLen())*abs > maxExactPowerBits {
+		return nil, ErrInvalidCalculation
+	}
+	num := new(big.Int).Exp(base.Num(), big.NewInt(abs), nil)
+	den := new(big.Int).Exp(base.Denom(), big.NewInt(abs), nil)
+	if n < 0 {
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// floorRat округляет значение вниз до целого
+func floorRat(r *big.Rat) *big.Rat {
+	// Div — евклидово деление: при положительном знаменателе это округление вниз
+	return new(big.Rat).SetInt(new(big.Int).Div(r.Num(), r.Denom()))
+}
+
+// CallDecimal вызывает зарегистрированную функцию над точными значениями: через Func.Decimal,
+// если она задана (у abs, min, max, round и sqrt), иначе через Fn над float64
+func CallDecimal(name string, args []*big.Rat) (*big.Rat, error) {
+	fn, ok := LookupFunc(name)
+	if !ok {
+		return nil, ErrUnknownFunction
+	}
+	if !fn.acceptsArgs(len(args)) {
+		return nil, ErrInvalidArgsCount
+	}
+
+	if fn.Decimal != nil {
+		time.Sleep(fn.Duration)
+		return fn.Decimal(args)
+	}
+
+	floats := make([]float64, len(args))
+	for i, arg := range args {
+		floats[i], _ = arg.Float64()
+	}
+	result, err := Call(name, floats)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).SetFloat64(result), nil
+}
+
+func absDecimal(args []*big.Rat) (*big.Rat, error) {
+	return new(big.Rat).Abs(args[0]), nil
+}
+
+// roundDecimal округляет до целого; половины округляются от нуля, как у math.Round
+func roundDecimal(args []*big.Rat) (*big.Rat, error) {
+	half := new(big.Rat).SetFrac64(int64(args[0].Sign()), 2)
+	shifted := new(big.Rat).Add(args[0], half)
+	return new(big.Rat).SetInt(new(big.Int).Quo(shifted.Num(), shifted.Denom())), nil
+}
+
+// sqrtDecimal вычисляет корень с точностью Decimal.Precision бит
+func sqrtDecimal(args []*big.Rat) (*big.Rat, error) {
+	if args[0].Sign() < 0 {
+		return nil, ErrNegativeSqrt
+	}
+	f := new(big.Float).SetPrec(Decimal.Precision).SetRat(args[0])
+	result, _ := new(big.Float).SetPrec(Decimal.Precision).Sqrt(f).Rat(nil)
+	return result, nil
+}
+
+// extremumDecimal возвращает min (sign = -1) или max (sign = 1) точных значений
+func extremumDecimal(sign int) func(args []*big.Rat) (*big.Rat, error) {
+	return func(args []*big.Rat) (*big.Rat, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) == sign {
+				result = arg
+			}
+		}
+		return new(big.Rat).Set(result), nil
+	}
+}
+
+// EvalDecimal вычисляет разобранное выражение точно, подставляя значения переменных из vars
+func EvalDecimal(expr *Expr, vars map[string]*big.Rat) (*big.Rat, error) {
+	if expr == nil || expr.Root == nil {
+		return nil, ErrInvalidExpression
+	}
+	var missing []string
+	for _, name := range Variables(expr) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &UnboundVariablesError{Names: missing}
+	}
+	return evalDecimalNode(expr.Root, vars)
+}
+
+func evalDecimalNode(n Node, vars map[string]*big.Rat) (*big.Rat, error) {
+	switch n := n.(type) {
+	case *NumberLit:
+		return ParseDecimal(n.Text)
+	case *VarRef:
+		return vars[n.Name], nil
+	case *GroupExpr:
+		return evalDecimalNode(n.Inner, vars)
+	case *UnaryExpr:
+		val, err := evalDecimalNode(n.Operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Op {
+		case "+":
+			return val, nil
+		case "-":
+			return new(big.Rat).Neg(val), nil
+		}
+		return nil, ErrInvalidOperand
+	case *BinaryExpr:
+		a, err := evalDecimalNode(n.Left, vars)
+		if err != nil {
+			return nil, err
+		}
+		b, err := evalDecimalNode(n.Right, vars)
+		if err != nil {
+			return nil, err
+		}
+		return ApplyDecimal(n.Op, a, b)
+	case *CallExpr:
+		args := make([]*big.Rat, len(n.Args))
+		for i, arg := range n.Args {
+			val, err := evalDecimalNode(arg, vars)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = val
+		}
+		return CallDecimal(n.Name, args)
+	}
+	return nil, ErrInvalidCalculation
+}
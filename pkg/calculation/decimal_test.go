@@ -0,0 +1,198 @@
+package calculation
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestEvalDecimal(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"0.1 + 0.2", "0.3"},
+		{"1 / 3 * 3", "1"},
+		{"1 / 3", "0.33333333333333333333"},
+		{"2 / 3", "0.66666666666666666667"},
+		{"-2 / 3", "-0.66666666666666666667"},
+		{"7 // 2", "3"},
+		{"-7 // 2", "-4"},
+		{"7.5 % 2", "1.5"},
+		{"-7 % 3", "-1"},
+		{"2 ^ -2", "0.25"},
+		{"0.1 ^ 3", "0.001"},
+		{"1.005 * 1000", "1005"},
+		{"max(0.1, 0.2) - min(0.3, 0.05)", "0.15"},
+		{"round(2.5) + round(-2.5)", "0"},
+		{"abs(-0.7)", "0.7"},
+		{"sqrt(0.04)", "0.2"},
+		{"sqrt(2)", "1.4142135623730950488"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			expr, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка разбора: %v", err)
+			}
+			result, err := EvalDecimal(expr, nil)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка для выражения: %s: %v", tt.expression, err)
+			}
+			if got := FormatDecimal(result, Decimal); got != tt.expected {
+				t.Errorf("Ожидаемый результат: %s, получено: %s для выражения: %s", tt.expected, got, tt.expression)
+			}
+		})
+	}
+}
+
+func TestEvalDecimalErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		target     error
+	}{
+		{"1 / 0", ErrInvalidZero},
+		{"1 % 0", ErrInvalidZero},
+		{"1 // 0", ErrInvalidZero},
+		{"0 ^ -1", ErrInvalidCalculation},
+		{"(10 ^ 10000) ^ 10000", ErrInvalidCalculation},
+		{"(1 / 10 ^ 10000) ^ 10000", ErrInvalidCalculation},
+		{"sqrt(-4)", ErrNegativeSqrt},
+		{"log(0)", ErrNonPositiveLog},
+		{"price * 2", ErrUnboundVariable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			expr, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка разбора: %v", err)
+			}
+			if _, err := EvalDecimal(expr, nil); !errors.Is(err, tt.target) {
+				t.Errorf("Ожидалась ошибка %v, получено: %v", tt.target, err)
+			}
+		})
+	}
+}
+
+func TestEvalDecimalVariables(t *testing.T) {
+	expr, err := Parse("price * qty")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка разбора: %v", err)
+	}
+	price, _ := ParseDecimal("19.99")
+	qty, _ := ParseDecimal("3")
+
+	result, err := EvalDecimal(expr, map[string]*big.Rat{"price": price, "qty": qty})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if got := FormatDecimal(result, Decimal); got != "59.97" {
+		t.Errorf("Ожидаемый результат: 59.97, получено: %s", got)
+	}
+}
+
+func TestFormatDecimalRounding(t *testing.T) {
+	tests := []struct {
+		value    string
+		mode     big.RoundingMode
+		expected string
+	}{
+		{"0.125", big.ToNearestEven, "0.12"},
+		{"0.135", big.ToNearestEven, "0.14"},
+		{"0.125", big.ToNearestAway, "0.13"},
+		{"-0.125", big.ToNearestAway, "-0.13"},
+		{"0.129", big.ToZero, "0.12"},
+		{"0.121", big.AwayFromZero, "0.13"},
+		{"-0.121", big.ToNegativeInf, "-0.13"},
+		{"-0.129", big.ToPositiveInf, "-0.12"},
+		{"1/3", big.ToNearestEven, "0.33"},
+		{"-0.001", big.ToNearestEven, "0"},
+		{"12", big.ToNearestEven, "12"},
+		{"0.5", big.ToNearestEven, "0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value+" "+tt.mode.String(), func(t *testing.T) {
+			r, err := ParseDecimal(tt.value)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка: %v", err)
+			}
+			got := FormatDecimal(r, DecimalContext{Scale: 2, Rounding: tt.mode})
+			if got != tt.expected {
+				t.Errorf("Ожидаемый результат: %s, получено: %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFormatDecimalNegativeScale(t *testing.T) {
+	r, _ := ParseDecimal("2.5")
+	if got := FormatDecimal(r, DecimalContext{Scale: -3, Rounding: big.ToNearestAway}); got != "3" {
+		t.Errorf("Ожидаемый результат: 3, получено: %s", got)
+	}
+}
+
+func TestDecimalFromEnv(t *testing.T) {
+	defaults := DecimalContext{Scale: 20, Rounding: big.ToNearestEven, Precision: 256}
+	tests := []struct {
+		scale, precision, rounding string
+		expected                   DecimalContext
+	}{
+		{"", "", "", defaults},
+		{"5", "64", "ToZero", DecimalContext{Scale: 5, Rounding: big.ToZero, Precision: 64}},
+		{"0", "1", "", DecimalContext{Scale: 0, Rounding: big.ToNearestEven, Precision: 1}},
+		// Недопустимые значения заменяются значениями по умолчанию
+		{"-1", "0", "bankers", defaults},
+		{"abc", "-64", "", defaults},
+	}
+	for _, tt := range tests {
+		t.Setenv("DECIMAL_SCALE", tt.scale)
+		t.Setenv("DECIMAL_PRECISION", tt.precision)
+		t.Setenv("DECIMAL_ROUNDING", tt.rounding)
+		if got := decimalFromEnv(defaults); got != tt.expected {
+			t.Errorf("DECIMAL_SCALE=%q DECIMAL_PRECISION=%q DECIMAL_ROUNDING=%q: ожидалось %+v, получено: %+v",
+				tt.scale, tt.precision, tt.rounding, tt.expected, got)
+		}
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	if mode, ok := ParseRoundingMode("toNearestAway"); !ok || mode != big.ToNearestAway {
+		t.Errorf("Ожидался режим ToNearestAway, получено: %v, %v", mode, ok)
+	}
+	if _, ok := ParseRoundingMode("bankers"); ok {
+		t.Error("Ожидалась ошибка для неизвестного режима")
+	}
+}
+
+func TestCallDecimalRegistered(t *testing.T) {
+	abs, _ := LookupFunc("abs")
+	defer Register(abs)
+
+	// Замена через RegisterFunc без точной реализации вычисляется через float64
+	RegisterFunc("abs", 1, func(args []float64) (float64, error) {
+		return -args[0], nil
+	}, 0)
+	result, err := CallDecimal("abs", []*big.Rat{big.NewRat(-3, 2)})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if result.Cmp(big.NewRat(3, 2)) != 0 {
+		t.Errorf("Ожидаемый результат: 3/2, получено: %s", result.RatString())
+	}
+
+	Register(Func{Name: "third", Arity: 1, Fn: func(args []float64) (float64, error) {
+		return args[0] / 3, nil
+	}, Decimal: func(args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Quo(args[0], big.NewRat(3, 1)), nil
+	}})
+	result, err = CallDecimal("third", []*big.Rat{big.NewRat(1, 1)})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if result.Cmp(big.NewRat(1, 3)) != 0 {
+		t.Errorf("Ожидаемый результат: 1/3, получено: %s", result.RatString())
+	}
+}
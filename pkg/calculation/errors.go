@@ -1,6 +1,10 @@
 package calculation
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	ErrInvalidExpression  = errors.New("некорректное выражение")
@@ -9,4 +13,89 @@ var (
 	ErrInvalidOperand     = errors.New("неподдерживаемый оператор")
 	ErrInvalidValuesCount = errors.New("недостаточно значений для операции")
 	ErrInvalidCalculation = errors.New("ошибка вычисления")
+	ErrOverflow           = errors.New("результат вне диапазона чисел")
+	ErrUnknownFunction    = errors.New("неизвестная функция")
+	ErrInvalidArgsCount   = errors.New("неверное число аргументов функции")
+	ErrInvalidDomain      = errors.New("аргумент вне области определения функции")
+	ErrNegativeSqrt       = errors.New("квадратный корень из отрицательного числа")
+	ErrNonPositiveLog     = errors.New("логарифм неположительного числа")
+	ErrUnboundVariable    = errors.New("не заданы значения переменных")
+)
+
+// Коды ошибок для клиентов API: в отличие от текста ошибки, они не меняются
+const (
+	CodeInvalidExpression  = "INVALID_EXPRESSION"
+	CodeInvalidParentheses = "UNBALANCED_PARENTHESES"
+	CodeDivisionByZero     = "DIVISION_BY_ZERO"
+	CodeUnsupportedOp      = "UNSUPPORTED_OPERATOR"
+	CodeInvalidValuesCount = "INVALID_VALUES_COUNT"
+	CodeCalculationError   = "CALCULATION_ERROR"
+	CodeOverflow           = "OVERFLOW"
+	CodeUnknownFunction    = "UNKNOWN_FUNCTION"
+	CodeInvalidArgsCount   = "INVALID_ARGS_COUNT"
+	CodeInvalidDomain      = "INVALID_DOMAIN"
+	CodeNegativeSqrt       = "NEGATIVE_SQRT"
+	CodeNonPositiveLog     = "NON_POSITIVE_LOG"
+	CodeUnboundVariable    = "UNBOUND_VARIABLE"
+	CodeUnknown            = "UNKNOWN_ERROR"
 )
+
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidExpression, CodeInvalidExpression},
+	{ErrInvalidParentheses, CodeInvalidParentheses},
+	{ErrInvalidZero, CodeDivisionByZero},
+	{ErrInvalidOperand, CodeUnsupportedOp},
+	{ErrInvalidValuesCount, CodeInvalidValuesCount},
+	{ErrInvalidCalculation, CodeCalculationError},
+	{ErrOverflow, CodeOverflow},
+	{ErrUnknownFunction, CodeUnknownFunction},
+	{ErrInvalidArgsCount, CodeInvalidArgsCount},
+	{ErrInvalidDomain, CodeInvalidDomain},
+	{ErrNegativeSqrt, CodeNegativeSqrt},
+	{ErrNonPositiveLog, CodeNonPositiveLog},
+	{ErrUnboundVariable, CodeUnboundVariable},
+}
+
+// ErrorCode возвращает код ошибки для err, в том числе обернутой.
+// Для ошибок не из этого пакета возвращается CodeUnknown
+func ErrorCode(err error) string {
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code
+		}
+	}
+	return CodeUnknown
+}
+
+// SyntaxError — ошибка разбора выражения с позицией (смещением в байтах от начала строки).
+// Err — одна из ошибок выше, ее можно проверить через errors.Is
+type SyntaxError struct {
+	Pos int
+	Msg string
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s (позиция %d)", e.Msg, e.Pos)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// UnboundVariablesError — в выражении есть переменные, для которых не заданы значения.
+// Сравнивается через errors.Is с ErrUnboundVariable
+type UnboundVariablesError struct {
+	Names []string
+}
+
+func (e *UnboundVariablesError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnboundVariable, strings.Join(e.Names, ", "))
+}
+
+func (e *UnboundVariablesError) Unwrap() error {
+	return ErrUnboundVariable
+}
@@ -0,0 +1,119 @@
+package calculation
+
+import (
+	"math"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// Variadic — арность функции, принимающей любое число аргументов, но не меньше одного
+const Variadic = -1
+
+// Func — функция, доступная в выражениях
+type Func struct {
+	Name  string
+	Arity int
+	Fn    func(args []float64) (float64, error)
+	// Decimal вычисляет функцию точно в десятичном режиме. Если не задана, CallDecimal
+	// вычисляет функцию через Fn над float64
+	Decimal  func(args []*big.Rat) (*big.Rat, error)
+	Duration time.Duration // имитация длительного вычисления, как у операторов
+}
+
+var (
+	funcsMutex = &sync.RWMutex{}
+	funcs      = make(map[string]Func)
+)
+
+func init() {
+	functionTime := getEnvAsDuration("TIME_FUNCTIONS_MS", 300)
+
+	Register(Func{Name: "sqrt", Arity: 1, Fn: func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, ErrNegativeSqrt
+		}
+		return math.Sqrt(args[0]), nil
+	}, Decimal: sqrtDecimal, Duration: functionTime})
+	RegisterFunc("log", 1, func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, ErrNonPositiveLog
+		}
+		return math.Log(args[0]), nil
+	}, functionTime)
+	Register(Func{Name: "abs", Arity: 1, Fn: unary(math.Abs), Decimal: absDecimal, Duration: functionTime})
+	Register(Func{Name: "round", Arity: 1, Fn: unary(math.Round), Decimal: roundDecimal, Duration: functionTime})
+	RegisterFunc("sin", 1, unary(math.Sin), functionTime)
+	RegisterFunc("cos", 1, unary(math.Cos), functionTime)
+	Register(Func{Name: "min", Arity: Variadic, Fn: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	}, Decimal: extremumDecimal(-1), Duration: functionTime})
+	Register(Func{Name: "max", Arity: Variadic, Fn: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	}, Decimal: extremumDecimal(1), Duration: functionTime})
+}
+
+// RegisterFunc добавляет функцию в реестр или заменяет уже зарегистрированную с тем же именем.
+// arity — число аргументов или Variadic. В десятичном режиме функция вычисляется через float64;
+// точную реализацию можно передать в Func.Decimal через Register
+func RegisterFunc(name string, arity int, fn func(args []float64) (float64, error), duration time.Duration) {
+	Register(Func{Name: name, Arity: arity, Fn: fn, Duration: duration})
+}
+
+// Register добавляет функцию fn в реестр под именем fn.Name или заменяет уже зарегистрированную
+func Register(fn Func) {
+	funcsMutex.Lock()
+	defer funcsMutex.Unlock()
+	funcs[fn.Name] = fn
+}
+
+// LookupFunc ищет функцию в реестре
+func LookupFunc(name string) (Func, bool) {
+	funcsMutex.RLock()
+	defer funcsMutex.RUnlock()
+	fn, ok := funcs[name]
+	return fn, ok
+}
+
+// Call вызывает зарегистрированную функцию
+func Call(name string, args []float64) (float64, error) {
+	fn, ok := LookupFunc(name)
+	if !ok {
+		return 0, ErrUnknownFunction
+	}
+	if !fn.acceptsArgs(len(args)) {
+		return 0, ErrInvalidArgsCount
+	}
+
+	time.Sleep(fn.Duration)
+	result, err := fn.Fn(args)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, ErrInvalidDomain
+	}
+	return result, nil
+}
+
+func (f Func) acceptsArgs(n int) bool {
+	if f.Arity == Variadic {
+		return n > 0
+	}
+	return n == f.Arity
+}
+
+// unary оборачивает функцию одного аргумента из пакета math
+func unary(fn func(float64) float64) func(args []float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		return fn(args[0]), nil
+	}
+}
@@ -0,0 +1,91 @@
+package calculation
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"sqrt(16)", 4},
+		{"abs(-3)", 3},
+		{"min(4, 2, 8)", 2},
+		{"max(4, 2, 8)", 8},
+		{"max(5)", 5},
+		{"round(2.5)", 3},
+		{"log(1)", 0},
+		{"sin(0)", 0},
+		{"cos(0)", 1},
+		{"2 * sqrt(9) + max(1, 2)", 8},
+		{"sqrt(max(3, 4) ^ 2 + 9)", 5},
+		{"-abs(-2)", -2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Calc(tt.expression)
+			if err != nil {
+				t.Fatalf("Неожиданная ошибка для выражения: %s: %v", tt.expression, err)
+			}
+			if math.Abs(result-tt.expected) > 1e-9 {
+				t.Errorf("Ожидаемый результат: %f, получено: %f для выражения: %s", tt.expected, result, tt.expression)
+			}
+		})
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		target     error
+	}{
+		{"sqrt(-1)", ErrNegativeSqrt},
+		{"log(0)", ErrNonPositiveLog},
+		{"log(-5)", ErrNonPositiveLog},
+		{"foo(1)", ErrUnknownFunction},
+		{"sqrt(1, 2)", ErrInvalidArgsCount},
+		{"max()", ErrInvalidExpression},
+		{"sqrt 4", ErrInvalidExpression},
+		{"sqrt(4", ErrInvalidParentheses},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := Calc(tt.expression)
+			if !errors.Is(err, tt.target) {
+				t.Errorf("Ожидалась ошибка %v, получено: %v", tt.target, err)
+			}
+		})
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	RegisterFunc("hypot", 2, func(args []float64) (float64, error) {
+		return math.Hypot(args[0], args[1]), nil
+	}, 0)
+	RegisterFunc("inv", 1, func(args []float64) (float64, error) {
+		if args[0] == 0 {
+			return 0, ErrInvalidDomain
+		}
+		return 1 / args[0], nil
+	}, 0)
+
+	result, err := Calc("hypot(3, 4) + inv(2)")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if result != 5.5 {
+		t.Errorf("Ожидаемый результат: 5.5, получено: %f", result)
+	}
+
+	if _, err := Call("inv", []float64{0}); !errors.Is(err, ErrInvalidDomain) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrInvalidDomain, err)
+	}
+	if _, err := Call("hypot", []float64{1}); !errors.Is(err, ErrInvalidArgsCount) {
+		t.Errorf("Ожидалась ошибка %v, получено: %v", ErrInvalidArgsCount, err)
+	}
+}
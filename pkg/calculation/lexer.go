@@ -0,0 +1,88 @@
+package calculation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type TokenKind int
+
+const (
+	TokenNumber TokenKind = iota
+	TokenOperator
+	TokenLParen
+	TokenRParen
+	TokenIdent
+	TokenComma
+	TokenEOF
+)
+
+// Token — лексема выражения. Pos — смещение в байтах от начала строки
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Value float64
+	Pos   int
+}
+
+// Tokenize разбивает выражение на числа, идентификаторы, операторы, скобки и запятые,
+// пропуская пробельные символы.
+// Последней в потоке всегда идет лексема TokenEOF
+func Tokenize(expression string) ([]Token, error) {
+	var tokens []Token
+	for i := 0; i < len(expression); {
+		char := rune(expression[i])
+		switch {
+		case unicode.IsSpace(char):
+			i++
+		case isDigit(expression[i]) || char == '.':
+			start := i
+			for i < len(expression) && (isDigit(expression[i]) || expression[i] == '.') {
+				i++
+			}
+			val, err := strconv.ParseFloat(expression[start:i], 64)
+			if err != nil {
+				return nil, &SyntaxError{Pos: start, Msg: fmt.Sprintf("неверное число: %s", expression[start:i]), Err: ErrInvalidExpression}
+			}
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: expression[start:i], Value: val, Pos: start})
+		case strings.HasPrefix(expression[i:], "//"):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: "//", Pos: i})
+			i += 2
+		case isOperator(expression[i]):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: string(char), Pos: i})
+			i++
+		case char == '(':
+			tokens = append(tokens, Token{Kind: TokenLParen, Text: "(", Pos: i})
+			i++
+		case char == ')':
+			tokens = append(tokens, Token{Kind: TokenRParen, Text: ")", Pos: i})
+			i++
+		case char == ',':
+			tokens = append(tokens, Token{Kind: TokenComma, Text: ",", Pos: i})
+			i++
+		case isLetter(expression[i]):
+			start := i
+			for i < len(expression) && (isLetter(expression[i]) || isDigit(expression[i])) {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: expression[start:i], Pos: start})
+		default:
+			return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("недопустимый символ: %q", expression[i]), Err: ErrInvalidExpression}
+		}
+	}
+	return append(tokens, Token{Kind: TokenEOF, Pos: len(expression)}), nil
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+func isLetter(char byte) bool {
+	return char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char == '_'
+}
+
+func isOperator(char byte) bool {
+	return char == '+' || char == '-' || char == '*' || char == '/' || char == '%' || char == '^'
+}
@@ -0,0 +1,155 @@
+package calculation
+
+import "fmt"
+
+// parser разбирает поток лексем методом рекурсивного спуска,
+// бинарные операции — по приоритету (см. precedence).
+// Унарные + и - связывают сильнее * / % //, но слабее ^:
+// 2 * -4 = 2 * (-4), -2 ^ 2 = -(2 ^ 2), 2 ^ -1 = 2 ^ (-1)
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+// Parse разбирает выражение в дерево. Ошибки разбора имеют тип *SyntaxError
+func Parse(expression string) (*Expr, error) {
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		if tok.Kind == TokenRParen {
+			return nil, &SyntaxError{Pos: tok.Pos, Msg: "лишняя закрывающая скобка", Err: ErrInvalidParentheses}
+		}
+		return nil, &SyntaxError{Pos: tok.Pos, Msg: fmt.Sprintf("неожиданный символ: %s", tok.Text), Err: ErrInvalidExpression}
+	}
+	return &Expr{Source: expression, Root: root}, nil
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseBinary разбирает последовательность операндов, связанных
+// операторами с приоритетом не ниже minPrec
+func (p *parser) parseBinary(minPrec int) (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		prec := precedence(tok.Text)
+		if tok.Kind != TokenOperator || prec < minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: tok.Text, Left: left, Right: right, OpPos: tok.Pos}
+	}
+}
+
+// parseUnary разбирает операнд с необязательными унарными + и -.
+// Степень связывает сильнее унарного оператора, поэтому разбирается здесь же;
+// показатель разбирается рекурсивно, что дает правую ассоциативность: 2^3^2 = 2^(3^2)
+func (p *parser) parseUnary() (Node, error) {
+	tok := p.peek()
+	if tok.Kind == TokenOperator && (tok.Text == "+" || tok.Text == "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: tok.Text, Operand: operand, OpPos: tok.Pos}, nil
+	}
+
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind == TokenOperator && tok.Text == "^" {
+		p.next()
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: tok.Text, Left: base, Right: exponent, OpPos: tok.Pos}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.next()
+	switch tok.Kind {
+	case TokenNumber:
+		return &NumberLit{Value: tok.Value, Text: tok.Text, Position: tok.Pos}, nil
+	case TokenLParen:
+		inner, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		closing := p.next()
+		if closing.Kind != TokenRParen {
+			return nil, &SyntaxError{Pos: closing.Pos, Msg: "ожидалась закрывающая скобка", Err: ErrInvalidParentheses}
+		}
+		return &GroupExpr{Inner: inner, Lparen: tok.Pos, Rparen: closing.Pos}, nil
+	case TokenIdent:
+		if p.peek().Kind == TokenLParen {
+			return p.parseCall(tok)
+		}
+		return &VarRef{Name: tok.Text, Position: tok.Pos}, nil
+	case TokenEOF:
+		return nil, &SyntaxError{Pos: tok.Pos, Msg: "неожиданный конец выражения", Err: ErrInvalidExpression}
+	default:
+		return nil, &SyntaxError{Pos: tok.Pos, Msg: fmt.Sprintf("ожидалось число, получено: %s", tok.Text), Err: ErrInvalidExpression}
+	}
+}
+
+// parseCall разбирает вызов функции name(arg, ...) и проверяет число аргументов по реестру
+func (p *parser) parseCall(name Token) (Node, error) {
+	fn, ok := LookupFunc(name.Text)
+	if !ok {
+		return nil, &SyntaxError{Pos: name.Pos, Msg: fmt.Sprintf("неизвестная функция: %s", name.Text), Err: ErrUnknownFunction}
+	}
+	p.next() // открывающая скобка
+
+	call := &CallExpr{Name: name.Text, NamePos: name.Pos}
+	for {
+		arg, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		tok := p.next()
+		if tok.Kind == TokenComma {
+			continue
+		}
+		if tok.Kind != TokenRParen {
+			return nil, &SyntaxError{Pos: tok.Pos, Msg: "ожидалась запятая или закрывающая скобка", Err: ErrInvalidParentheses}
+		}
+		call.Rparen = tok.Pos
+		break
+	}
+
+	if !fn.acceptsArgs(len(call.Args)) {
+		return nil, &SyntaxError{Pos: name.Pos, Msg: fmt.Sprintf("неверное число аргументов функции %s: %d", name.Text, len(call.Args)), Err: ErrInvalidArgsCount}
+	}
+	return call, nil
+}
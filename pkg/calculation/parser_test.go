@@ -0,0 +1,186 @@
+package calculation
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := Tokenize(" 12.5*(3 -1)")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	expected := []Token{
+		{Kind: TokenNumber, Text: "12.5", Value: 12.5, Pos: 1},
+		{Kind: TokenOperator, Text: "*", Pos: 5},
+		{Kind: TokenLParen, Text: "(", Pos: 6},
+		{Kind: TokenNumber, Text: "3", Value: 3, Pos: 7},
+		{Kind: TokenOperator, Text: "-", Pos: 9},
+		{Kind: TokenNumber, Text: "1", Value: 1, Pos: 10},
+		{Kind: TokenRParen, Text: ")", Pos: 11},
+		{Kind: TokenEOF, Pos: 12},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Ожидалось %d лексем, получено: %d (%v)", len(expected), len(tokens), tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("Лексема %d: ожидалось %+v, получено %+v", i, expected[i], tokens[i])
+		}
+	}
+}
+
+func TestTokenizeOperators(t *testing.T) {
+	tokens, err := Tokenize("7//2%3^2/1")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	var ops []string
+	for _, tok := range tokens {
+		if tok.Kind == TokenOperator {
+			ops = append(ops, tok.Text)
+		}
+	}
+	expected := []string{"//", "%", "^", "/"}
+	if len(ops) != len(expected) {
+		t.Fatalf("Ожидаемые операторы: %v, получено: %v", expected, ops)
+	}
+	for i := range expected {
+		if ops[i] != expected[i] {
+			t.Errorf("Ожидаемые операторы: %v, получено: %v", expected, ops)
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		pos   int
+	}{
+		{"Double dot", "1..2", 0},
+		{"Trailing dot number", "3 + 1.2.3", 4},
+		{"Unknown character", "1 + $", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Tokenize(tt.input)
+			var serr *SyntaxError
+			if !errors.As(err, &serr) {
+				t.Fatalf("Ожидалась SyntaxError, получено: %v", err)
+			}
+			if serr.Pos != tt.pos {
+				t.Errorf("Ожидаемая позиция: %d, получено: %d", tt.pos, serr.Pos)
+			}
+			if !errors.Is(err, ErrInvalidExpression) {
+				t.Errorf("Ожидалась ErrInvalidExpression, получено: %v", err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	expr, err := Parse("1 + 2 * (3 - 4)")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	sum, ok := expr.Root.(*BinaryExpr)
+	if !ok || sum.Op != "+" {
+		t.Fatalf("Ожидался корень +, получено: %#v", expr.Root)
+	}
+	if lit, ok := sum.Left.(*NumberLit); !ok || lit.Value != 1 {
+		t.Errorf("Ожидался левый операнд 1, получено: %#v", sum.Left)
+	}
+	mul, ok := sum.Right.(*BinaryExpr)
+	if !ok || mul.Op != "*" || mul.OpPos != 6 {
+		t.Fatalf("Ожидался правый операнд *, получено: %#v", sum.Right)
+	}
+	group, ok := mul.Right.(*GroupExpr)
+	if !ok || group.Lparen != 8 || group.Rparen != 14 {
+		t.Fatalf("Ожидалась группа в скобках, получено: %#v", mul.Right)
+	}
+	if sub, ok := group.Inner.(*BinaryExpr); !ok || sub.Op != "-" {
+		t.Errorf("Ожидалось вычитание внутри скобок, получено: %#v", group.Inner)
+	}
+	if expr.Root.Pos() != 0 {
+		t.Errorf("Ожидаемая позиция корня: 0, получено: %d", expr.Root.Pos())
+	}
+}
+
+func TestParseUnary(t *testing.T) {
+	expr, err := Parse("2 * -4")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	mul, ok := expr.Root.(*BinaryExpr)
+	if !ok || mul.Op != "*" {
+		t.Fatalf("Ожидался корень *, получено: %#v", expr.Root)
+	}
+	neg, ok := mul.Right.(*UnaryExpr)
+	if !ok || neg.Op != "-" || neg.OpPos != 4 {
+		t.Fatalf("Ожидался унарный минус, получено: %#v", mul.Right)
+	}
+	if lit, ok := neg.Operand.(*NumberLit); !ok || lit.Value != 4 {
+		t.Errorf("Ожидался операнд 4, получено: %#v", neg.Operand)
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	expr, err := Parse("max(price, 1) * qty")
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+
+	mul := expr.Root.(*BinaryExpr)
+	call, ok := mul.Left.(*CallExpr)
+	if !ok || call.Name != "max" || len(call.Args) != 2 {
+		t.Fatalf("Ожидался вызов max, получено: %#v", mul.Left)
+	}
+	if ref, ok := call.Args[0].(*VarRef); !ok || ref.Name != "price" || ref.Position != 4 {
+		t.Errorf("Ожидалась переменная price, получено: %#v", call.Args[0])
+	}
+	if ref, ok := mul.Right.(*VarRef); !ok || ref.Name != "qty" {
+		t.Errorf("Ожидалась переменная qty, получено: %#v", mul.Right)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		pos    int
+		target error
+	}{
+		{"Empty", "", 0, ErrInvalidExpression},
+		{"Trailing operator", "1 +", 3, ErrInvalidExpression},
+		{"Double operator", "1 + * 2", 4, ErrInvalidExpression},
+		{"Unclosed parenthesis", "(1 + 2", 6, ErrInvalidParentheses},
+		{"Extra parenthesis", "1 + 2)", 5, ErrInvalidParentheses},
+		{"Missing operator", "1 2", 2, ErrInvalidExpression},
+		{"Unary multiplication", "*2", 0, ErrInvalidExpression},
+		{"Dangling unary minus", "2 * -", 5, ErrInvalidExpression},
+		{"Triple slash", "6 /// 2", 4, ErrInvalidExpression},
+		{"Dangling power", "2 ^", 3, ErrInvalidExpression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			var serr *SyntaxError
+			if !errors.As(err, &serr) {
+				t.Fatalf("Ожидалась SyntaxError, получено: %v", err)
+			}
+			if serr.Pos != tt.pos {
+				t.Errorf("Ожидаемая позиция: %d, получено: %d", tt.pos, serr.Pos)
+			}
+			if !errors.Is(err, tt.target) {
+				t.Errorf("Ожидалась ошибка %v, получено: %v", tt.target, err)
+			}
+		})
+	}
+}
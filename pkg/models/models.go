@@ -1,19 +1,158 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
+// Типы задач
+const (
+	TaskOperation = "operation" // бинарная операция Arg1 Operation Arg2
+	TaskFunction  = "function"  // вызов функции Function(Args...)
+)
+
+// Режимы точности вычислений
+const (
+	PrecisionFloat   = "float"   // float64, по умолчанию
+	PrecisionDecimal = "decimal" // точная десятичная арифметика, значения передаются строками
+)
+
+// Статусы выражения
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"    // причина в полях Error и ErrorCode
+	StatusCancelled  = "cancelled" // вычисление остановлено по запросу пользователя
+)
+
+// Task — одна операция, готовая к вычислению агентом.
+// Аргументы всегда числа: задача попадает в очередь только после того,
+// как вычислены все задачи, от результатов которых она зависит.
 type Task struct {
-	ID        string    `json:"id"`
-	Numbers   []float64 `json:"numbers"`
-	Operators []string  `json:"operators"`
+	ID           string    `json:"id"`
+	ExpressionID string    `json:"expression_id"`
+	Type         string    `json:"type"`
+	Arg1         float64   `json:"arg1"`
+	Arg2         float64   `json:"arg2"`
+	Operation    string    `json:"operation,omitempty"`
+	Function     string    `json:"function,omitempty"`
+	Args         []float64 `json:"args,omitempty"`
+	// В режиме PrecisionDecimal аргументы передаются точно, строками:
+	// два для операции, по одному на каждый аргумент функции
+	Precision   string   `json:"precision,omitempty"`
+	DecimalArgs []string `json:"decimal_args,omitempty"`
+	// Аренда: агент должен вернуть результат до LeaseDeadline, иначе задача снова попадет в очередь.
+	// Attempt — номер попытки выполнения, начиная с 1, LeaseToken — непрозрачный ключ аренды,
+	// который агент возвращает вместе с результатом
+	Attempt       int       `json:"attempt,omitempty"`
+	LeaseDeadline time.Time `json:"lease_deadline,omitzero"`
+	LeaseToken    string    `json:"lease_token,omitempty"`
 }
 
+// Result — результат задачи. Если вычисление не удалось, агент заполняет ErrorCode и Error
 type Result struct {
-	ID     string  `json:"id"`
-	Result float64 `json:"result"`
+	ID           string  `json:"id"`
+	ExpressionID string  `json:"expression_id,omitempty"` // выражение задачи из Task.ExpressionID
+	Result       float64 `json:"result"`
+	Decimal      string  `json:"decimal,omitempty"`    // точный результат в режиме PrecisionDecimal
+	ErrorCode    string  `json:"error_code,omitempty"` // код ошибки, см. calculation.ErrorCode
+	Error        string  `json:"error,omitempty"`
+	// Ключ аренды из Task.LeaseToken: сервер принимает результат, только если задача все еще
+	// выдана в этой аренде
+	LeaseToken string `json:"lease_token,omitempty"`
 }
 
 type Expression struct {
-	ID         string  `json:"id"`
-	Expression string  `json:"expression"`
-	Status     string  `json:"status"`
-	Result     float64 `json:"result,omitempty"`
+	ID         string                 `json:"id"`
+	Expression string                 `json:"expression"`
+	Variables  map[string]json.Number `json:"variables,omitempty"`
+	Precision  string                 `json:"precision,omitempty"`
+	Status     string                 `json:"status"`
+	Result     float64                `json:"result,omitempty"`
+	Decimal    string                 `json:"decimal,omitempty"`    // точный результат в режиме PrecisionDecimal
+	ErrorCode  string                 `json:"error_code,omitempty"` // код ошибки в статусе failed
+	Error      string                 `json:"error,omitempty"`      // описание ошибки в статусе failed
+	// После завершения выражение отправляется POST-запросом на CallbackURL; попытки видны в Deliveries
+	CallbackURL string     `json:"callback_url,omitempty"`
+	Deliveries  []Delivery `json:"deliveries,omitempty"`
+	BatchID     string     `json:"batch_id,omitempty"` // пакет, в составе которого отправлено выражение
+	CreatedAt   time.Time  `json:"created_at,omitzero"`
+	CompletedAt time.Time  `json:"completed_at,omitzero"` // когда выражение перешло в completed, failed или cancelled
+}
+
+// Delivery — попытка отправить завершенное выражение на его CallbackURL
+type Delivery struct {
+	Attempt    int       `json:"attempt"` // номер попытки, начиная с 1
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"` // код ответа получателя; 0 — ответа нет
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"` // получатель ответил 2xx
+}
+
+// ExpressionError — ошибка проверки выражения: описание, код, позиция синтаксической ошибки
+// (смещение в байтах) и переменные, для которых не заданы значения
+type ExpressionError struct {
+	Error            string   `json:"error"`
+	ErrorCode        string   `json:"error_code"`
+	Position         *int     `json:"position,omitempty"`
+	MissingVariables []string `json:"missing_variables,omitempty"`
+}
+
+// Batch — выражения, отправленные одним запросом POST /api/v1/calculate/batch
+type Batch struct {
+	ID    string      `json:"id"`
+	Items []BatchItem `json:"items"` // в порядке запроса
+}
+
+// BatchItem — выражение пакета: ID принятого выражения или ошибка, по которой оно отклонено
+type BatchItem struct {
+	ClientID     string `json:"client_id,omitempty"` // ID, который клиент передал вместе с выражением
+	ExpressionID string `json:"expression_id,omitempty"`
+	*ExpressionError
+}
+
+// BatchProgress — состояние пакета в ответе GET /api/v1/batches/{id}
+type BatchProgress struct {
+	ID     string         `json:"id"`
+	Total  int            `json:"total"`
+	Counts map[string]int `json:"counts"` // число выражений по статусам; отклоненные — под ключом rejected
+	Done   bool           `json:"done"`   // все принятые выражения завершены
+	Items  []BatchResult  `json:"items"`
+}
+
+// BatchResult — элемент пакета вместе с текущим состоянием его выражения
+type BatchResult struct {
+	BatchItem
+	Expression *Expression `json:"expression,omitempty"`
+}
+
+// Типы событий выражения
+const (
+	EventStatus = "status" // выражение сменило статус, в Expression — его текущее состояние
+	EventTask   = "task"   // вычислена подзадача выражения, в Task и Result — она и ее результат
+)
+
+// ExpressionEvent — событие из потока GET /api/v1/expressions/{id}/events и /ws
+type ExpressionEvent struct {
+	Type       string      `json:"type"`
+	Expression *Expression `json:"expression,omitempty"`
+	Task       *Task       `json:"task,omitempty"`
+	Result     *Result     `json:"result,omitempty"`
+}
+
+// WorkerStats — счетчики одного воркера агента, передаются оркестратору с heartbeat
+type WorkerStats struct {
+	ID        int   `json:"id"`
+	Processed int64 `json:"processed"` // задач, вычисленных успешно
+	Failed    int64 `json:"failed"`    // задач, вычисление которых завершилось ошибкой
+	Busy      bool  `json:"busy"`
+}
+
+// Agent — сведения, которые агент сообщает оркестратору при регистрации
+type Agent struct {
+	ID             string   `json:"id"`
+	ComputingPower int      `json:"computing_power"`
+	Version        string   `json:"version,omitempty"`
+	Capabilities   []string `json:"capabilities,omitempty"` // типы задач и режимы точности, которые умеет агент
 }